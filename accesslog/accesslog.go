@@ -0,0 +1,141 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+/*
+Package accesslog provides access logging middleware for YAM, keyed by route
+pattern rather than by path.
+
+	mux := yam.New()
+	mux.Use(accesslog.Middleware(accesslog.NewWriter(os.Stdout, accesslog.JSON)))
+	mux.Route("/users/:id").Get(users)
+	mux.Route("/health").Get(health).Meta(accesslog.Skip, true)
+
+Entries can be written in Common Log Format, JSON or logfmt to an io.Writer,
+to a log/slog Logger, or to any other Sink. Routes, or whole subtrees, can
+opt out of logging by setting the Skip metadata key.
+*/
+package accesslog
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thisissoon/yam"
+)
+
+// Route metadata key, routes with this set to true are not logged
+const Skip = "accesslog.skip"
+
+// A single access log entry
+type Entry struct {
+	Time      time.Time     // Time the request was received
+	Method    string        // Request method
+	Path      string        // Request path and query
+	Proto     string        // Request protocol, for example HTTP/1.1
+	Route     string        // Pattern of the matched route, empty if no route matched
+	Params    url.Values    // Values of the route pattern segments
+	Status    int           // Response status code
+	Bytes     int64         // Size of the response body
+	Duration  time.Duration // Time taken to serve the request
+	RemoteIP  string        // IP address of the client, see yam.ClientIP
+	RequestID string        // ID of the request
+}
+
+// Receives access log entries
+type Sink interface {
+	Log(*Entry)
+}
+
+// Adapter allowing an ordinary function to be used as a Sink
+type SinkFunc func(*Entry)
+
+// Implements the Sink interface
+func (f SinkFunc) Log(e *Entry) {
+	f(e)
+}
+
+// Returns middleware logging each request to the sink. It can be added to
+// the mux with Yam.Use or wrap the mux.
+func Middleware(sink Sink) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r = yam.WithRouteInfo(r)
+			rw := yam.WrapWriter(w)
+			// The request line as sent, YAM adds the route values to the
+			// query of the URL when it matches the request
+			path := r.RequestURI
+			if path == "" {
+				path = r.URL.RequestURI()
+			}
+
+			next.ServeHTTP(rw, r)
+
+			e := &Entry{
+				Time:      start,
+				Method:    r.Method,
+				Path:      path,
+				Proto:     r.Proto,
+				Status:    rw.Status(),
+				Bytes:     rw.BytesWritten(),
+				Duration:  time.Since(start),
+				RemoteIP:  yam.ClientIP(r),
+				RequestID: yam.RequestID(r),
+			}
+			if info := yam.CurrentRoute(r); info != nil {
+				if skip, _ := info.Meta[Skip].(bool); skip {
+					return
+				}
+				e.Route = info.Pattern
+				e.Params = info.Params
+			}
+
+			sink.Log(e)
+		})
+	}
+}
+
+// Returns the params keyed by name, without the leading colon
+func params(values url.Values) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(values))
+	for k, v := range values {
+		m[strings.TrimPrefix(k, ":")] = strings.Join(v, ",")
+	}
+	return m
+}
+
+// Returns a Sink logging entries to the slog Logger at the Info level
+func NewSlog(l *slog.Logger) Sink {
+	return SinkFunc(func(e *Entry) {
+		attrs := []slog.Attr{
+			slog.String("method", e.Method),
+			slog.String("path", e.Path),
+			slog.String("route", e.Route),
+		}
+		if p := params(e.Params); p != nil {
+			group := make([]any, 0, len(p))
+			for k, v := range p {
+				group = append(group, slog.String(k, v))
+			}
+			attrs = append(attrs, slog.Group("params", group...))
+		}
+		attrs = append(attrs,
+			slog.Int("status", e.Status),
+			slog.Int64("bytes", e.Bytes),
+			slog.Duration("duration", e.Duration),
+			slog.String("remote_ip", e.RemoteIP),
+		)
+		if e.RequestID != "" {
+			attrs = append(attrs, slog.String("request_id", e.RequestID))
+		}
+		l.LogAttrs(context.Background(), slog.LevelInfo, "request", attrs...)
+	})
+}
@@ -0,0 +1,162 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thisissoon/yam"
+)
+
+func TestMiddleware(t *testing.T) {
+	var entries []*Entry
+
+	mux := yam.New()
+	mux.Config.RequestID = true
+	mux.Config.RequestIDHeader = "X-Correlation-ID"
+	mux.Use(Middleware(SinkFunc(func(e *Entry) {
+		entries = append(entries, e)
+	})))
+	mux.Route("/users/:id").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+	mux.Route("/health").Meta(Skip, true).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/users/42?x=1", nil)
+	req.Header.Set("X-Correlation-ID", "abc")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nope", nil))
+
+	if len(entries) != 2 {
+		t.Fatalf("Logged %v entries, should be %v", len(entries), 2)
+	}
+
+	e := entries[0]
+	if e.Method != "GET" || e.Path != "/users/42?x=1" || e.Route != "/users/:id" || e.Params.Get(":id") != "42" {
+		t.Errorf("Entry was %+v", e)
+	}
+	if e.Status != http.StatusCreated || e.Bytes != 5 {
+		t.Errorf("Status was %v and bytes %v, should be %v and %v", e.Status, e.Bytes, http.StatusCreated, 5)
+	}
+	if e.RemoteIP != "192.0.2.1" || e.RequestID != "abc" {
+		t.Errorf("RemoteIP was %v and RequestID %v", e.RemoteIP, e.RequestID)
+	}
+
+	if entries[1].Status != http.StatusNotFound || entries[1].Route != "" {
+		t.Errorf("Entry was %+v, should be an unmatched 404", entries[1])
+	}
+}
+
+func TestMiddlewareWrappingMux(t *testing.T) {
+	var entry *Entry
+
+	mux := yam.New()
+	mux.Config.RequestID = true
+	mux.Config.RequestIDHeader = "X-Correlation-ID"
+	mux.Route("/users/:id").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h := Middleware(SinkFunc(func(e *Entry) { entry = e }))(mux)
+
+	req := httptest.NewRequest("GET", "/users/1?x=1", nil)
+	req.Header.Set("X-Correlation-ID", "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if entry == nil || entry.Route != "/users/:id" {
+		t.Fatalf("Entry was %+v, should have route %v", entry, "/users/:id")
+	}
+	if entry.Path != "/users/1?x=1" || entry.RequestID != "abc" {
+		t.Errorf("Path was %v and RequestID %v, should be %v and %v", entry.Path, entry.RequestID, "/users/1?x=1", "abc")
+	}
+}
+
+func TestWrapPreservesInterfaces(t *testing.T) {
+	mux := yam.New()
+	mux.Use(Middleware(SinkFunc(func(e *Entry) {})))
+	mux.Route("/stream").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("Writer should implement http.Flusher")
+		}
+		if _, ok := w.(http.Hijacker); ok {
+			t.Error("Writer should not implement http.Hijacker")
+		}
+	}))
+
+	// httptest.ResponseRecorder is a Flusher but not a Hijacker
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/stream", nil))
+}
+
+var entry = &Entry{
+	Time:      time.Date(2000, 10, 10, 13, 55, 36, 0, time.UTC),
+	Method:    "GET",
+	Path:      "/users/42?x=1",
+	Proto:     "HTTP/1.1",
+	Route:     "/users/:id",
+	Params:    url.Values{":id": []string{"42"}},
+	Status:    200,
+	Bytes:     2326,
+	Duration:  1500 * time.Microsecond,
+	RemoteIP:  "127.0.0.1",
+	RequestID: "abc 123",
+}
+
+func TestFormats(t *testing.T) {
+	var tests = []struct {
+		format   Format
+		expected string
+	}{
+		{
+			CommonLog,
+			`127.0.0.1 - - [10/Oct/2000:13:55:36 +0000] "GET /users/42?x=1 HTTP/1.1" 200 2326` + "\n",
+		},
+		{
+			Logfmt,
+			`time=2000-10-10T13:55:36Z method=GET path="/users/42?x=1" route=/users/:id param.id=42 status=200 bytes=2326 duration=1.5ms remote_ip=127.0.0.1 request_id="abc 123"` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		NewWriter(&buf, test.format).Log(entry)
+		if buf.String() != test.expected {
+			t.Errorf("Line was\n%sshould be\n%s", buf.String(), test.expected)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	NewWriter(&buf, JSON).Log(entry)
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["route"] != "/users/:id" || m["status"] != float64(200) || m["duration"] != 0.0015 {
+		t.Errorf("JSON was %s", buf.String())
+	}
+	if params, _ := m["params"].(map[string]interface{}); params["id"] != "42" {
+		t.Errorf("Params were %v", m["params"])
+	}
+}
+
+func TestSlog(t *testing.T) {
+	var buf bytes.Buffer
+	NewSlog(slog.New(slog.NewTextHandler(&buf, nil))).Log(entry)
+
+	for _, s := range []string{"msg=request", "route=/users/:id", "params.id=42", "status=200", "request_id=\"abc 123\""} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("Log %q should contain %q", buf.String(), s)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format of entries written by a writer Sink
+type Format int
+
+const (
+	CommonLog Format = iota // NCSA Common Log Format
+	JSON                    // One JSON object per line
+	Logfmt                  // key=value pairs
+)
+
+// Returns a Sink writing entries to w in the format, one entry per line.
+// Writes are serialised so w does not need to be safe for concurrent use.
+func NewWriter(w io.Writer, f Format) Sink {
+	var mu sync.Mutex
+	return SinkFunc(func(e *Entry) {
+		var b []byte
+		switch f {
+		case JSON:
+			b = formatJSON(e)
+		case Logfmt:
+			b = formatLogfmt(e)
+		default:
+			b = formatCommonLog(e)
+		}
+
+		mu.Lock()
+		w.Write(b)
+		mu.Unlock()
+	})
+}
+
+// host ident authuser [date] "request line" status bytes
+func formatCommonLog(e *Entry) []byte {
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+
+	return []byte(fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s\n",
+		e.RemoteIP,
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, e.Path, e.Proto,
+		e.Status, size))
+}
+
+func formatJSON(e *Entry) []byte {
+	b, _ := json.Marshal(struct {
+		Time      string            `json:"time"`
+		Method    string            `json:"method"`
+		Path      string            `json:"path"`
+		Route     string            `json:"route,omitempty"`
+		Params    map[string]string `json:"params,omitempty"`
+		Status    int               `json:"status"`
+		Bytes     int64             `json:"bytes"`
+		Duration  float64           `json:"duration"`
+		RemoteIP  string            `json:"remote_ip"`
+		RequestID string            `json:"request_id,omitempty"`
+	}{
+		Time:      e.Time.Format(time.RFC3339Nano),
+		Method:    e.Method,
+		Path:      e.Path,
+		Route:     e.Route,
+		Params:    params(e.Params),
+		Status:    e.Status,
+		Bytes:     e.Bytes,
+		Duration:  e.Duration.Seconds(),
+		RemoteIP:  e.RemoteIP,
+		RequestID: e.RequestID,
+	})
+
+	return append(b, '\n')
+}
+
+func formatLogfmt(e *Entry) []byte {
+	var buf bytes.Buffer
+	pair := func(key, value string) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		if value == "" || strings.ContainsAny(value, " =\"\\") || strings.ContainsFunc(value, isControl) {
+			buf.WriteString(strconv.Quote(value))
+		} else {
+			buf.WriteString(value)
+		}
+	}
+
+	pair("time", e.Time.Format(time.RFC3339Nano))
+	pair("method", e.Method)
+	pair("path", e.Path)
+	if e.Route != "" {
+		pair("route", e.Route)
+	}
+	p := params(e.Params)
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pair("param."+k, p[k])
+	}
+	pair("status", strconv.Itoa(e.Status))
+	pair("bytes", strconv.FormatInt(e.Bytes, 10))
+	pair("duration", e.Duration.String())
+	pair("remote_ip", e.RemoteIP)
+	if e.RequestID != "" {
+		pair("request_id", e.RequestID)
+	}
+	buf.WriteByte('\n')
+
+	return buf.Bytes()
+}
+
+func isControl(r rune) bool {
+	return r < ' ' || r == 0x7f
+}
@@ -0,0 +1,164 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+/*
+Package auth provides authentication middleware for YAM supporting HTTP Basic,
+opaque bearer tokens and JWTs.
+
+	keys, err := auth.LoadJWKS("/etc/api/jwks.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := yam.New()
+	api := mux.Route("/api").Use(auth.Middleware(
+		auth.JWT("api", keys, auth.JWTOptions{Issuer: "https://id.example.com"}),
+		auth.Basic("api", auth.StaticUsers(map[string]string{"admin": secret})),
+	))
+	api.Route("/me").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(auth.FromContext(r.Context()).Subject))
+	}))
+
+Requests without valid credentials are replied to with a 401 Unauthorized
+through the error handler of the mux, with a WWW-Authenticate challenge for
+each scheme. JWT only handles bearer tokens in JWS compact form, so it can be
+combined with Bearer for opaque tokens. OPTIONS requests are not authenticated so CORS preflight
+requests and the default OPTIONS handler keep working.
+
+RBAC implements yam.Authorizer, checking the requirements declared with
+Route.Require against the roles of the principal:
+
+	mux.Config.Authorizer = auth.NewRBAC().Grant("admin", "*").Grant("clerk", "orders:read")
+	api.Route("/orders").Require("orders:read")
+*/
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/thisissoon/yam"
+)
+
+var (
+	// Returned by an Authenticator when the request has no credentials for
+	// its scheme, the next Authenticator is tried
+	ErrNoCredentials = errors.New("auth: no credentials")
+
+	// Returned by an Authenticator when the credentials of the request are
+	// not valid
+	ErrInvalidCredentials = errors.New("auth: invalid credentials")
+)
+
+// The authenticated identity of a request
+type Principal struct {
+	Subject string                 // User name, token owner or JWT subject
+	Scheme  string                 // Scheme the request was authenticated with, for example Basic
+	Roles   []string               // Roles of the principal, from the roles claim of a JWT
+	Claims  map[string]interface{} // JWT claims or other attributes of the principal
+}
+
+// Authenticates requests with one HTTP authentication scheme
+type Authenticator interface {
+	// Returns the principal of the request, ErrNoCredentials when the
+	// request has no credentials for the scheme, or an error wrapping
+	// ErrInvalidCredentials when they are not valid
+	Authenticate(r *http.Request) (*Principal, error)
+
+	// Returns the WWW-Authenticate challenge for the scheme, err is the
+	// error returned by Authenticate
+	Challenge(err error) string
+}
+
+type contextKey struct{}
+
+// Returns the principal of a request, nil if it is not authenticated
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
+
+// Returns a copy of the context with the principal
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// Returns middleware authenticating requests with the first authenticator the
+// request has credentials for. It can be added to a route with Route.Use, or
+// to the mux with Yam.Use. The principal is placed on the request context.
+//
+// Requests without credentials, or with credentials that are not valid, are
+// replied to with a 401 Unauthorized and a WWW-Authenticate challenge for
+// every authenticator, errors other than ErrNoCredentials and
+// ErrInvalidCredentials with the error handler of the mux.
+func Middleware(authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Preflight requests do not carry credentials
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var failed Authenticator
+			var failure error
+			for _, a := range authenticators {
+				p, err := a.Authenticate(r)
+				switch {
+				case err == nil && p != nil:
+					next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), p)))
+					return
+				case errors.Is(err, ErrNoCredentials):
+					continue
+				case err == nil || errors.Is(err, ErrInvalidCredentials):
+					failed, failure = a, err
+				default:
+					yam.Error(w, r, err)
+					return
+				}
+				break
+			}
+
+			var challenges []string
+			for _, a := range authenticators {
+				err := ErrNoCredentials
+				if a == failed {
+					err = failure
+				}
+				challenges = append(challenges, a.Challenge(err))
+			}
+			for _, c := range mergeChallenges(challenges) {
+				w.Header().Add("WWW-Authenticate", c)
+			}
+			detail := "the request is not authenticated"
+			if failed != nil {
+				detail = "the credentials of the request are not valid"
+			}
+			yam.Error(w, r, yam.NewProblem(http.StatusUnauthorized, detail))
+		})
+	}
+}
+
+// Removes duplicate challenges, such as those of JWT and Bearer authenticators
+// for the same realm. A challenge is dropped when another is the same, or
+// the same with parameters added, like the error of a failed authenticator.
+func mergeChallenges(challenges []string) []string {
+	var merged []string
+	for i, c := range challenges {
+		duplicate := false
+		for j, other := range challenges {
+			if (other == c && j < i) || strings.HasPrefix(other, c+", ") {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			merged = append(merged, c)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,378 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thisissoon/yam"
+)
+
+var b64 = base64.RawURLEncoding
+
+// Signs a token with claims using the algorithm of the key
+func sign(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+// Writes a JWKS file with the public keys and returns the key set loaded from
+// it
+func writeJWKS(t *testing.T, secret []byte, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) *KeySet {
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "oct", "kid": "hs", "k": b64.EncodeToString(secret)},
+			{"kty": "RSA", "kid": "rs", "use": "sig", "n": b64.EncodeToString(rsaKey.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "es", "crv": "P-256", "x": b64.EncodeToString(ecKey.X.Bytes()), "y": b64.EncodeToString(ecKey.Y.Bytes())},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+			{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": "AA"},
+		},
+	}
+	b, _ := json.Marshal(jwks)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := LoadJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.Keys) != 3 {
+		t.Fatalf("Key set had %d keys, should have 3", len(ks.Keys))
+	}
+
+	return ks
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := writeJWKS(t, secret, rsaKey, ecKey)
+
+	now := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	a := JWT("api", keys, JWTOptions{Issuer: "https://id.example.com", Audience: "api", Leeway: time.Minute, now: func() time.Time { return now }})
+
+	claims := func(extra ...interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":   "alice",
+			"iss":   "https://id.example.com",
+			"aud":   []string{"api", "web"},
+			"exp":   now.Add(time.Hour).Unix(),
+			"roles": []string{"admin"},
+		}
+		for i := 0; i < len(extra); i += 2 {
+			if extra[i+1] == nil {
+				delete(c, extra[i].(string))
+				continue
+			}
+			c[extra[i].(string)] = extra[i+1]
+		}
+		return c
+	}
+
+	var tests = []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"HS256", sign(t, "HS256", "hs", secret, claims()), ""},
+		{"RS256", sign(t, "RS256", "rs", rsaKey, claims()), ""},
+		{"ES256", sign(t, "ES256", "es", ecKey, claims()), ""},
+		{"no kid", sign(t, "ES256", "", ecKey, claims()), ""},
+		{"string audience", sign(t, "HS256", "hs", secret, claims("aud", "api")), ""},
+		{"within leeway", sign(t, "HS256", "hs", secret, claims("exp", now.Add(-30*time.Second).Unix())), ""},
+		{"wrong key", sign(t, "ES256", "es", otherKey, claims()), "signature"},
+		{"wrong kid", sign(t, "HS256", "rs", secret, claims()), "signature"},
+		{"alg none", b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"sub":"alice"}`)) + ".", "signature"},
+		{"public key as secret", sign(t, "HS256", "rs", rsaKey.N.Bytes(), claims()), "signature"},
+		{"expired", sign(t, "HS256", "hs", secret, claims("exp", now.Add(-time.Hour).Unix())), "expired"},
+		{"not yet valid", sign(t, "HS256", "hs", secret, claims("nbf", now.Add(time.Hour).Unix())), "not valid yet"},
+		{"issuer", sign(t, "HS256", "hs", secret, claims("iss", "https://evil.example.com")), "issuer"},
+		{"audience", sign(t, "HS256", "hs", secret, claims("aud", "web")), "audience"},
+		{"no audience", sign(t, "HS256", "hs", secret, claims("aud", nil)), "audience"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		p, err := a.Authenticate(req)
+
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: Error was %v", test.name, err)
+				continue
+			}
+			if p.Subject != "alice" || p.Scheme != "Bearer" || len(p.Roles) != 1 || p.Roles[0] != "admin" {
+				t.Errorf("%s: Principal was %+v", test.name, p)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidCredentials) || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: Error was %v, should be invalid credentials mentioning %q", test.name, err, test.err)
+		}
+	}
+
+	for _, token := range []string{"opaque", "abc.def", "abc.def.ghi"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if _, err := a.Authenticate(req); err != ErrNoCredentials {
+			t.Errorf("%q: Error was %v, tokens that are not JWTs should be left to other authenticators", token, err)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tokens := TokenVerifierFunc(func(r *http.Request, token string) (*Principal, error) {
+		if token != "opaque" {
+			return nil, ErrInvalidCredentials
+		}
+		return &Principal{Subject: "service"}, nil
+	})
+
+	mux := yam.New()
+	api := mux.Route("/api").Use(Middleware(
+		Bearer("api", tokens),
+		Basic("api", StaticUsers(map[string]string{"admin": "secret"})),
+	))
+	api.Route("/me").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := FromContext(r.Context())
+		w.Write([]byte(p.Scheme + " " + p.Subject))
+	}))
+	mux.Route("/public").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if FromContext(r.Context()) != nil {
+			t.Error("Public requests should not have a principal")
+		}
+	}))
+
+	var tests = []struct {
+		method     string
+		path       string
+		auth       string
+		status     int
+		body       string
+		challenges []string
+	}{
+		{"GET", "/api/me", "Bearer opaque", http.StatusOK, "Bearer service", nil},
+		{"GET", "/api/me", "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret")), http.StatusOK, "Basic admin", nil},
+		{"GET", "/api/me", "basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret")), http.StatusOK, "Basic admin", nil},
+		{"GET", "/api/me", "", http.StatusUnauthorized, "", []string{`Bearer realm="api"`, `Basic realm="api", charset="UTF-8"`}},
+		{"GET", "/api/me", "Bearer wrong", http.StatusUnauthorized, "", []string{`Bearer realm="api", error="invalid_token"`, `Basic realm="api", charset="UTF-8"`}},
+		{"GET", "/api/me", "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:wrong")), http.StatusUnauthorized, "", nil},
+		{"GET", "/api/me", "Basic " + base64.StdEncoding.EncodeToString([]byte("nobody:secret")), http.StatusUnauthorized, "", nil},
+		{"GET", "/api/me", "Digest username=admin", http.StatusUnauthorized, "", nil},
+		{"OPTIONS", "/api/me", "", http.StatusOK, "", nil},
+		{"GET", "/public", "", http.StatusOK, "", nil},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%s %s %q: Status was %d, should be %d", test.method, test.path, test.auth, res.Code, test.status)
+			continue
+		}
+		if test.body != "" && res.Body.String() != test.body {
+			t.Errorf("%s %s %q: Body was %q, should be %q", test.method, test.path, test.auth, res.Body.String(), test.body)
+		}
+		if test.status == http.StatusUnauthorized && len(res.Header().Values("WWW-Authenticate")) != 2 {
+			t.Errorf("%s %s %q: WWW-Authenticate was %q, should have a challenge per scheme", test.method, test.path, test.auth, res.Header().Values("WWW-Authenticate"))
+		}
+		if test.challenges != nil && strings.Join(res.Header().Values("WWW-Authenticate"), "\n") != strings.Join(test.challenges, "\n") {
+			t.Errorf("%s %s %q: WWW-Authenticate was %q, should be %q", test.method, test.path, test.auth, res.Header().Values("WWW-Authenticate"), test.challenges)
+		}
+		if test.method == "OPTIONS" && res.Header().Get("Allow") == "" {
+			t.Error("OPTIONS should be served by the default handler")
+		}
+	}
+}
+
+func TestMiddlewareJWTAndBearer(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	keys := &KeySet{Keys: []*Key{{ID: "hs", Algorithm: "HS256", Key: secret}}}
+	tokens := TokenVerifierFunc(func(r *http.Request, token string) (*Principal, error) {
+		if token != "opaque" {
+			return nil, ErrInvalidCredentials
+		}
+		return &Principal{Subject: "service"}, nil
+	})
+
+	mux := yam.New()
+	mux.Route("/").Use(Middleware(JWT("api", keys, JWTOptions{}), Bearer("api", tokens))).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(FromContext(r.Context()).Subject))
+	}))
+
+	var tests = []struct {
+		auth       string
+		status     int
+		body       string
+		challenges []string
+	}{
+		{"Bearer opaque", http.StatusOK, "service", nil},
+		{"Bearer " + sign(t, "HS256", "hs", secret, map[string]interface{}{"sub": "alice"}), http.StatusOK, "alice", nil},
+		{"Bearer wrong", http.StatusUnauthorized, "", []string{`Bearer realm="api", error="invalid_token"`}},
+		{"", http.StatusUnauthorized, "", []string{`Bearer realm="api"`}},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%q: Status was %d, should be %d", test.auth, res.Code, test.status)
+			continue
+		}
+		if test.body != "" && res.Body.String() != test.body {
+			t.Errorf("%q: Body was %q, should be %q", test.auth, res.Body.String(), test.body)
+		}
+		if test.challenges != nil && strings.Join(res.Header().Values("WWW-Authenticate"), "\n") != strings.Join(test.challenges, "\n") {
+			t.Errorf("%q: WWW-Authenticate was %q, should be %q", test.auth, res.Header().Values("WWW-Authenticate"), test.challenges)
+		}
+	}
+}
+
+func TestMiddlewareError(t *testing.T) {
+	broken := TokenVerifierFunc(func(r *http.Request, token string) (*Principal, error) {
+		return nil, errors.New("database is down")
+	})
+
+	mux := yam.New()
+	mux.Route("/").Use(Middleware(Bearer("api", broken))).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer x")
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("Status was %d, verifier errors should be passed to the error handler", res.Code)
+	}
+}
+
+func TestBearerChallenge(t *testing.T) {
+	a := JWT("api", &KeySet{}, JWTOptions{})
+	challenge := a.Challenge(invalid("the token has expired"))
+
+	if challenge != `Bearer realm="api", error="invalid_token", error_description="invalid credentials: the token has expired"` {
+		t.Errorf("Challenge was %q", challenge)
+	}
+}
+
+func TestRBAC(t *testing.T) {
+	rbac := NewRBAC().
+		Grant("admin", "*").
+		Grant("clerk", "orders:read", "orders:write", "refunds:write").
+		Grant("auditor", "orders:read", "reports:*").
+		Revoke("clerk", "refunds:write")
+
+	var tests = []struct {
+		principal   *Principal
+		requirement string
+		allowed     bool
+	}{
+		{&Principal{Roles: []string{"clerk"}}, "orders:write", true},
+		{&Principal{Roles: []string{"clerk"}}, "refunds:write", false},
+		{&Principal{Roles: []string{"auditor"}}, "orders:write", false},
+		{&Principal{Roles: []string{"auditor"}}, "reports:monthly", true},
+		{&Principal{Roles: []string{"admin"}}, "anything", true},
+		{&Principal{Roles: []string{"auditor", "clerk"}}, "orders:write", true},
+		{&Principal{Roles: []string{"auditor"}}, "orders:write || role:auditor", true},
+		{&Principal{Roles: []string{"auditor"}}, "orders:read && role:clerk", false},
+		{&Principal{Roles: []string{"clerk"}}, "orders:read && role:clerk || role:admin", true},
+		{&Principal{Roles: []string{"clerk"}}, "role:admin", false},
+		{&Principal{Claims: map[string]interface{}{"scope": "orders:read profile"}}, "orders:read", true},
+		{&Principal{Claims: map[string]interface{}{"scope": "orders:read profile"}}, "orders:write", false},
+		{&Principal{}, "", false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req = req.WithContext(ContextWithPrincipal(req.Context(), test.principal))
+		allowed, err := rbac.Authorize(req, test.requirement)
+		if err != nil || allowed != test.allowed {
+			t.Errorf("%v %q: Allowed was %v (%v), should be %v", test.principal.Roles, test.requirement, allowed, err, test.allowed)
+		}
+	}
+
+	_, err := rbac.Authorize(httptest.NewRequest("GET", "/", nil), "orders:read")
+	var p *yam.Problem
+	if !errors.As(err, &p) || p.Status != http.StatusUnauthorized {
+		t.Errorf("Error was %v, requests without a principal should be unauthorized", err)
+	}
+}
+
+func TestRBACRoutes(t *testing.T) {
+	tokens := TokenVerifierFunc(func(r *http.Request, token string) (*Principal, error) {
+		return &Principal{Subject: token, Roles: []string{token}}, nil
+	})
+
+	mux := yam.New()
+	mux.Config.Authorizer = NewRBAC().Grant("clerk", "orders:read")
+	mux.Route("/orders").Use(Middleware(Bearer("api", tokens))).Require("orders:read").
+		Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for token, status := range map[string]int{"clerk": http.StatusOK, "guest": http.StatusForbidden, "": http.StatusUnauthorized} {
+		req := httptest.NewRequest("GET", "/orders", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != status {
+			t.Errorf("%q: Status was %d, should be %d", token, res.Code, status)
+		}
+	}
+}
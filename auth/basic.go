@@ -0,0 +1,152 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Verifies the user name and password of HTTP Basic requests
+type BasicVerifier interface {
+	// Returns the principal for the user, an error wrapping
+	// ErrInvalidCredentials when the password is wrong
+	VerifyBasic(r *http.Request, user, password string) (*Principal, error)
+}
+
+// Adapter allowing an ordinary function to be used as a BasicVerifier
+type BasicVerifierFunc func(r *http.Request, user, password string) (*Principal, error)
+
+// Implements the BasicVerifier interface
+func (f BasicVerifierFunc) VerifyBasic(r *http.Request, user, password string) (*Principal, error) {
+	return f(r, user, password)
+}
+
+// Returns a BasicVerifier for a fixed set of user names and passwords, the
+// passwords are compared in constant time
+func StaticUsers(users map[string]string) BasicVerifier {
+	hashes := make(map[string][32]byte, len(users))
+	for user, password := range users {
+		hashes[user] = sha256.Sum256([]byte(password))
+	}
+
+	return BasicVerifierFunc(func(r *http.Request, user, password string) (*Principal, error) {
+		want, ok := hashes[user]
+		got := sha256.Sum256([]byte(password))
+		if subtle.ConstantTimeCompare(want[:], got[:]) != 1 || !ok {
+			return nil, ErrInvalidCredentials
+		}
+		return &Principal{Subject: user}, nil
+	})
+}
+
+// Verifies opaque bearer tokens, for example by looking them up in a database
+type TokenVerifier interface {
+	// Returns the principal the token was issued to, an error wrapping
+	// ErrInvalidCredentials when the token is not valid
+	VerifyToken(r *http.Request, token string) (*Principal, error)
+}
+
+// Adapter allowing an ordinary function to be used as a TokenVerifier
+type TokenVerifierFunc func(r *http.Request, token string) (*Principal, error)
+
+// Implements the TokenVerifier interface
+func (f TokenVerifierFunc) VerifyToken(r *http.Request, token string) (*Principal, error) {
+	return f(r, token)
+}
+
+// Returns an Authenticator for HTTP Basic authentication (RFC 7617)
+func Basic(realm string, v BasicVerifier) Authenticator {
+	return &basic{realm: realm, verifier: v}
+}
+
+type basic struct {
+	realm    string
+	verifier BasicVerifier
+}
+
+// Implements the Authenticator interface
+func (b *basic) Authenticate(r *http.Request) (*Principal, error) {
+	if !hasScheme(r, "Basic") {
+		return nil, ErrNoCredentials
+	}
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	p, err := b.verifier.VerifyBasic(r, user, password)
+	if err != nil {
+		return nil, err
+	}
+	p.Scheme = "Basic"
+
+	return p, nil
+}
+
+// Implements the Authenticator interface
+func (b *basic) Challenge(err error) string {
+	return `Basic realm=` + strconv.Quote(b.realm) + `, charset="UTF-8"`
+}
+
+// Returns an Authenticator for opaque bearer tokens (RFC 6750)
+func Bearer(realm string, v TokenVerifier) Authenticator {
+	return &bearer{realm: realm, verify: v.VerifyToken}
+}
+
+type bearer struct {
+	realm  string
+	verify func(r *http.Request, token string) (*Principal, error)
+}
+
+// Implements the Authenticator interface
+func (b *bearer) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	p, err := b.verify(r, token)
+	if err != nil {
+		return nil, err
+	}
+	p.Scheme = "Bearer"
+
+	return p, nil
+}
+
+// Implements the Authenticator interface. Requests with a token that is not
+// valid are given the invalid_token error code, and its description.
+func (b *bearer) Challenge(err error) string {
+	challenge := `Bearer realm=` + strconv.Quote(b.realm)
+	if err != nil && !errors.Is(err, ErrNoCredentials) {
+		challenge += `, error="invalid_token"`
+		if description := strings.TrimPrefix(err.Error(), "auth: "); err != ErrInvalidCredentials {
+			challenge += `, error_description=` + strconv.Quote(description)
+		}
+	}
+
+	return challenge
+}
+
+// Reports whether the Authorization header of the request uses a scheme
+func hasScheme(r *http.Request, scheme string) bool {
+	header := r.Header.Get("Authorization")
+	return len(header) > len(scheme) && strings.EqualFold(header[:len(scheme)], scheme) && header[len(scheme)] == ' '
+}
+
+// Returns the bearer token of the request
+func bearerToken(r *http.Request) (string, bool) {
+	if !hasScheme(r, "Bearer") {
+		return "", false
+	}
+	token := strings.TrimSpace(r.Header.Get("Authorization")[len("Bearer "):])
+
+	return token, token != ""
+}
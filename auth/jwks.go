@@ -0,0 +1,143 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// A key of a JSON Web Key Set (RFC 7517)
+type Key struct {
+	ID        string      // Key ID, matched against the kid of a JWT
+	Algorithm string      // HS256, RS256 or ES256
+	Key       interface{} // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+// A set of keys JWTs are verified with
+type KeySet struct {
+	Keys []*Key
+}
+
+// Reads a JSON Web Key Set from a file
+func LoadJWKS(path string) (*KeySet, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseJWKS(b)
+}
+
+// Parses a JSON Web Key Set. Symmetric (oct), RSA and P-256 EC keys are
+// supported, keys of other types or for other uses are skipped.
+func ParseJWKS(b []byte) (*KeySet, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			K   string `json:"k"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("auth: parsing JWKS: %w", err)
+	}
+
+	ks := &KeySet{}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key := &Key{ID: k.Kid}
+		var err error
+		switch k.Kty {
+		case "oct":
+			key.Algorithm = "HS256"
+			key.Key, err = base64.RawURLEncoding.DecodeString(k.K)
+		case "RSA":
+			key.Algorithm = "RS256"
+			key.Key, err = rsaKey(k.N, k.E)
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			key.Algorithm = "ES256"
+			key.Key, err = ecKey(k.X, k.Y)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("auth: parsing JWKS key %d: %w", i, err)
+		}
+		if k.Alg != "" && k.Alg != key.Algorithm {
+			continue
+		}
+		ks.Keys = append(ks.Keys, key)
+	}
+
+	return ks, nil
+}
+
+func rsaKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(eb)
+	if len(nb) == 0 || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA key")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(exp.Int64())}, nil
+}
+
+func ecKey(x, y string) (*ecdsa.PublicKey, error) {
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	yb, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("invalid EC key")
+	}
+
+	return key, nil
+}
+
+// Returns the keys a JWT signed with alg and kid may have been signed with
+func (ks *KeySet) lookup(alg, kid string) []*Key {
+	var keys []*Key
+	for _, k := range ks.Keys {
+		if k.Algorithm == alg && (kid == "" || k.ID == kid) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,180 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Claims JWTs are validated against
+type JWTOptions struct {
+	Issuer   string        // Required iss claim, not checked when empty
+	Audience string        // Required value of the aud claim, not checked when empty
+	Leeway   time.Duration // Clock skew allowed when checking exp and nbf
+
+	now func() time.Time
+}
+
+// Returns an Authenticator for JWT bearer tokens signed with HS256, RS256 or
+// ES256 by a key of the set. The exp and nbf claims are always checked, the
+// sub claim becomes the subject of the principal and a roles claim its roles.
+func JWT(realm string, keys *KeySet, opts JWTOptions) Authenticator {
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+	j := &jwt{keys: keys, opts: opts}
+
+	return &bearer{realm: realm, verify: j.verify}
+}
+
+type jwt struct {
+	keys *KeySet
+	opts JWTOptions
+}
+
+// Returns an error wrapping ErrInvalidCredentials with a description
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidCredentials}, a...)...)
+}
+
+// Verifies the signature and claims of a token. Tokens that are not in JWS
+// compact form are left to other authenticators, such as Bearer for opaque
+// tokens, by returning ErrNoCredentials.
+func (j *jwt) verify(r *http.Request, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrNoCredentials
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrNoCredentials
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("the token signature is malformed")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range j.keys.lookup(header.Alg, header.Kid) {
+		if verifySignature(key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, invalid("the token signature is not valid")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalid("the token claims are malformed")
+	}
+	if err := j.validate(claims); err != nil {
+		return nil, err
+	}
+
+	p := &Principal{Claims: claims}
+	p.Subject, _ = claims["sub"].(string)
+	if roles, ok := claims["roles"].([]interface{}); ok {
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				p.Roles = append(p.Roles, s)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Checks the registered claims of a token
+func (j *jwt) validate(claims map[string]interface{}) error {
+	now := j.opts.now()
+
+	exp, ok := claims["exp"].(float64)
+	if ok && now.After(time.Unix(int64(exp), 0).Add(j.opts.Leeway)) {
+		return invalid("the token has expired")
+	}
+	if _, present := claims["exp"]; present && !ok {
+		return invalid("the token exp claim is not valid")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(j.opts.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return invalid("the token is not valid yet")
+	}
+
+	if j.opts.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != j.opts.Issuer {
+			return invalid("the token issuer is not valid")
+		}
+	}
+
+	if j.opts.Audience != "" {
+		found := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			found = aud == j.opts.Audience
+		case []interface{}:
+			for _, a := range aud {
+				if a == j.opts.Audience {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return invalid("the token audience is not valid")
+		}
+	}
+
+	return nil
+}
+
+// Decodes a base64url encoded JSON segment of a token
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	return json.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+// Reports whether the signature of a token was made with a key
+func verifySignature(key *Key, signed, signature []byte) bool {
+	hash := sha256.Sum256(signed)
+
+	switch k := key.Key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k, hash[:], r, s)
+	}
+
+	return false
+}
@@ -0,0 +1,131 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/thisissoon/yam"
+)
+
+// In memory role based access control implementing yam.Authorizer, safe for
+// concurrent use.
+//
+//	rbac := auth.NewRBAC().
+//		Grant("admin", "*").
+//		Grant("clerk", "orders:read", "orders:write").
+//		Grant("auditor", "orders:read", "reports:*")
+//	mux.Config.Authorizer = rbac
+//	mux.Route("/orders").Require("orders:read").RequireMethod("DELETE", "role:admin")
+//
+// A requirement is an expression of terms joined with && and ||, && binding
+// tighter, for example "orders:write || role:admin". A "role:name" term is
+// satisfied when the principal has the role, other terms are permissions
+// satisfied when a role of the principal is granted the permission, or when
+// it is one of the space separated scopes of the scope claim of a JWT.
+// Granted permissions ending in "*" match any permission with that prefix.
+type RBAC struct {
+	mu    sync.RWMutex
+	roles map[string][]string
+}
+
+// Constructs a new RBAC without any roles
+func NewRBAC() *RBAC {
+	return &RBAC{roles: make(map[string][]string)}
+}
+
+// Grants permissions to a role
+func (a *RBAC) Grant(role string, permissions ...string) *RBAC {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.roles[role] = append(a.roles[role], permissions...)
+
+	return a
+}
+
+// Removes permissions from a role
+func (a *RBAC) Revoke(role string, permissions ...string) *RBAC {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	granted := a.roles[role][:0]
+	for _, p := range a.roles[role] {
+		revoked := false
+		for _, r := range permissions {
+			if p == r {
+				revoked = true
+			}
+		}
+		if !revoked {
+			granted = append(granted, p)
+		}
+	}
+	a.roles[role] = granted
+
+	return a
+}
+
+// Implements the yam.Authorizer interface. Requests without a principal are
+// replied to with a 401 Unauthorized.
+func (a *RBAC) Authorize(r *http.Request, requirement string) (bool, error) {
+	p := FromContext(r.Context())
+	if p == nil {
+		return false, yam.NewProblem(http.StatusUnauthorized, "the request is not authenticated")
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, alternative := range strings.Split(requirement, "||") {
+		all := true
+		for _, term := range strings.Split(alternative, "&&") {
+			if !a.satisfies(p, strings.TrimSpace(term)) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Reports whether the principal satisfies a single term, the read lock must
+// be held
+func (a *RBAC) satisfies(p *Principal, term string) bool {
+	if term == "" {
+		return false
+	}
+	if role, ok := strings.CutPrefix(term, "role:"); ok {
+		for _, r := range p.Roles {
+			if r == role {
+				return true
+			}
+		}
+		return false
+	}
+
+	if scope, ok := p.Claims["scope"].(string); ok {
+		for _, s := range strings.Fields(scope) {
+			if s == term {
+				return true
+			}
+		}
+	}
+	for _, role := range p.Roles {
+		for _, granted := range a.roles[role] {
+			if granted == term || (strings.HasSuffix(granted, "*") && strings.HasPrefix(term, granted[:len(granted)-1])) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,165 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+)
+
+// Decides whether requests satisfy the requirements declared on routes with
+// Route.Require. The auth package has an RBAC implementation.
+type Authorizer interface {
+	// Reports whether the request satisfies the requirement. Errors are
+	// passed to the error handler, so a *Problem such as a 401 can be
+	// returned for requests that are not authenticated.
+	Authorize(r *http.Request, requirement string) (bool, error)
+}
+
+// Adapter allowing an ordinary function to be used as an Authorizer
+type AuthorizerFunc func(r *http.Request, requirement string) (bool, error)
+
+// Implements the Authorizer interface
+func (f AuthorizerFunc) Authorize(r *http.Request, requirement string) (bool, error) {
+	return f(r, requirement)
+}
+
+// Declares requirements, such as permissions or role expressions, that
+// requests to every method of the route and of the routes under it must
+// satisfy. Requirements are added to those of the routes above and are
+// checked by Config.Authorizer before the handler is called, requests not
+// satisfying all of them are replied to with a 403 Forbidden.
+//
+//	mux.Config.Authorizer = rbac
+//	orders := mux.Route("/orders").Use(auth.Middleware(jwt)).Require("orders:read")
+//	orders.RequireMethod("POST", "orders:write")
+//
+// Requirements run after route middleware, so authentication middleware added
+// with Use runs first. OPTIONS requests are not checked unless they have
+// requirements of their own.
+func (r *Route) Require(requirements ...string) *Route {
+	r.require = append(r.require, requirements...)
+
+	return r
+}
+
+// Declares requirements for one method of the route and of the routes under
+// it, in addition to those declared with Require. Requirements for GET apply
+// to HEAD unless HEAD has its own.
+func (r *Route) RequireMethod(method string, requirements ...string) *Route {
+	if r.requireMethod == nil {
+		r.requireMethod = make(map[string][]string)
+	}
+	r.requireMethod[method] = append(r.requireMethod[method], requirements...)
+
+	return r
+}
+
+// Returns the requirements requests with the method must satisfy, declared on
+// the route and on the routes above it
+func (r *Route) Requirements(method string) []string {
+	var routes []*Route
+	for route := r; route != nil; route = route.parent {
+		routes = append([]*Route{route}, routes...)
+	}
+
+	if method == http.MethodHead {
+		head := false
+		for _, route := range routes {
+			if _, ok := route.requireMethod[http.MethodHead]; ok {
+				head = true
+			}
+		}
+		if !head {
+			method = http.MethodGet
+		}
+	}
+
+	var requirements []string
+	for _, route := range routes {
+		if method != http.MethodOptions {
+			requirements = append(requirements, route.require...)
+		}
+		requirements = append(requirements, route.requireMethod[method]...)
+	}
+
+	return requirements
+}
+
+// Checks the requirements of the route for the request method with the
+// authorizer before calling the handler
+func (r *Route) authorizeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requirements := r.Requirements(req.Method)
+		if len(requirements) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		authorizer := r.yam.Config.Authorizer
+		if authorizer == nil {
+			// Fail closed, the requirements cannot be checked
+			Error(w, req, NewProblem(http.StatusInternalServerError, ""))
+			return
+		}
+		for _, requirement := range requirements {
+			ok, err := authorizer.Authorize(req, requirement)
+			if err != nil {
+				Error(w, req, err)
+				return
+			}
+			if !ok {
+				Error(w, req, NewProblem(http.StatusForbidden, ""))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// The requirements of a method of a route
+type Permission struct {
+	Pattern      string
+	Method       string
+	Requirements []string // Empty when the method is public
+}
+
+// Returns the requirements of every method of every route in the tree, in
+// tree order, for reviewing what the API exposes
+func (y *Yam) Permissions() []Permission {
+	var permissions []Permission
+	y.Walk(func(r *Route) error {
+		for _, method := range r.Methods() {
+			permissions = append(permissions, Permission{
+				Pattern:      r.Pattern(),
+				Method:       method,
+				Requirements: r.Requirements(method),
+			})
+		}
+		return nil
+	})
+
+	return permissions
+}
+
+// Writes the permissions of the tree as an aligned table, methods without
+// requirements are marked as public
+func (y *Yam) WritePermissions(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATTERN\tMETHOD\tREQUIRES")
+	for _, p := range y.Permissions() {
+		requires := strings.Join(p.Requirements, ", ")
+		if requires == "" {
+			requires = "(public)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Pattern, p.Method, requires)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequire(t *testing.T) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mux := New()
+	// Requests are granted the permissions in the X-Grants header
+	mux.Config.Authorizer = AuthorizerFunc(func(r *http.Request, requirement string) (bool, error) {
+		if r.Header.Get("X-Grants") == "" {
+			return false, NewProblem(http.StatusUnauthorized, "")
+		}
+		for _, g := range strings.Split(r.Header.Get("X-Grants"), ",") {
+			if g == requirement {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	orders := mux.Route("/orders").Require("orders:read").RequireMethod("POST", "orders:write").Get(fn).Post(fn)
+	orders.Route("/:id").RequireMethod("DELETE", "admin").Get(fn).Delete(fn)
+	mux.Route("/health").Get(fn)
+
+	var tests = []struct {
+		method string
+		path   string
+		grants string
+		status int
+	}{
+		{"GET", "/health", "", http.StatusOK},
+		{"GET", "/orders", "", http.StatusUnauthorized},
+		{"GET", "/orders", "other", http.StatusForbidden},
+		{"GET", "/orders", "orders:read", http.StatusOK},
+		{"HEAD", "/orders", "orders:read", http.StatusOK},
+		{"HEAD", "/orders", "other", http.StatusForbidden},
+		{"POST", "/orders", "orders:read", http.StatusForbidden},
+		{"POST", "/orders", "orders:write", http.StatusForbidden},
+		{"POST", "/orders", "orders:read,orders:write", http.StatusOK},
+		{"GET", "/orders/1", "orders:read", http.StatusOK},
+		{"DELETE", "/orders/1", "orders:read", http.StatusForbidden},
+		{"DELETE", "/orders/1", "orders:read,admin", http.StatusOK},
+		{"OPTIONS", "/orders", "", http.StatusOK},
+		{"PUT", "/orders", "orders:read", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.grants != "" {
+			req.Header.Set("X-Grants", test.grants)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%s %s %q: Status was %d, should be %d", test.method, test.path, test.grants, res.Code, test.status)
+		}
+	}
+}
+
+func TestRequireWithoutAuthorizer(t *testing.T) {
+	mux := New()
+	mux.Route("/").Require("admin").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called")
+	}))
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("Status was %d, requirements should fail closed", res.Code)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mux := New()
+	mux.Config.Options = false
+	mux.Route("/health").Get(fn)
+	orders := mux.Route("/orders").Require("orders:read").RequireMethod("POST", "orders:write").Get(fn).Post(fn)
+	orders.Route("/:id").RequireMethod("DELETE", "role:admin").Delete(fn)
+
+	var buf bytes.Buffer
+	if err := mux.WritePermissions(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `PATTERN      METHOD  REQUIRES
+/health      GET     (public)
+/health      HEAD    (public)
+/orders      GET     orders:read
+/orders      HEAD    orders:read
+/orders      POST    orders:read, orders:write
+/orders/:id  DELETE  orders:read, role:admin
+`
+	if buf.String() != expected {
+		t.Errorf("Permissions were\n%s\nshould be\n%s", buf.String(), expected)
+	}
+}
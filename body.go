@@ -0,0 +1,51 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net/http"
+)
+
+// Sets the maximum size in bytes of request bodies for the handlers of the
+// route, and of the routes under it unless they set their own, overriding
+// Config.MaxBodyBytes. A limit of zero or less disables the limit.
+//
+//	mux.Config.MaxBodyBytes = 64 << 10
+//	mux.Route("/uploads").MaxBody(100 << 20).Post(upload)
+func (r *Route) MaxBody(n int64) *Route {
+	r.maxBody = &n
+
+	return r
+}
+
+// Returns the body limit for the route, set on the route or inherited from
+// the routes above it, falling back to Config.MaxBodyBytes
+func (r *Route) effectiveMaxBody() int64 {
+	for route := r; route != nil; route = route.parent {
+		if route.maxBody != nil {
+			return *route.maxBody
+		}
+	}
+
+	return r.yam.Config.MaxBodyBytes
+}
+
+// Rejects requests declaring a Content-Length over the limit with a 413
+// Content Too Large, and limits reading the body of other requests, such as
+// chunked requests, to n bytes. Reading past the limit returns a
+// *http.MaxBytesError which the error handler replies to with a 413.
+func maxBodyHandler(next http.Handler, n int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			Error(w, r, &http.MaxBytesError{Limit: n})
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxBody(t *testing.T) {
+	read := HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		w.Write(b)
+		return nil
+	})
+
+	mux := New()
+	mux.Config.MaxBodyBytes = 8
+	mux.Route("/small").Post(read)
+	mux.Route("/uploads").MaxBody(16).Post(read)
+	mux.Route("/unlimited").MaxBody(0).Post(read)
+
+	var tests = []struct {
+		path    string
+		body    string
+		chunked bool
+		status  int
+	}{
+		{"/small", "12345678", false, http.StatusOK},
+		{"/small", "123456789", false, http.StatusRequestEntityTooLarge},
+		{"/small", "123456789", true, http.StatusRequestEntityTooLarge},
+		{"/uploads", "0123456789abcdef", false, http.StatusOK},
+		{"/uploads", "0123456789abcdefg", true, http.StatusRequestEntityTooLarge},
+		{"/unlimited", strings.Repeat("x", 1024), true, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		var body io.Reader = strings.NewReader(test.body)
+		if test.chunked {
+			// Hide the length so the body is read without a Content-Length
+			body = ioutil.NopCloser(body)
+		}
+		req := httptest.NewRequest("POST", test.path, body)
+		if test.chunked {
+			req.ContentLength = -1
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%s %d bytes: Status was %v, should be %v", test.path, len(test.body), res.Code, test.status)
+		}
+	}
+}
+
+func TestMaxBodyErrorHandler(t *testing.T) {
+	var reported error
+
+	mux := New()
+	mux.Config.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		reported = err
+		DefaultErrorHandler(w, r, err)
+	}
+	mux.Route("/").MaxBody(1).Post(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("POST", "/", strings.NewReader("too big")))
+
+	if _, ok := reported.(*http.MaxBytesError); !ok {
+		t.Errorf("Error handler was called with %v, should be a *http.MaxBytesError", reported)
+	}
+	if res.Body.String() != "413 Request Entity Too Large: the request body is over the limit of 1 bytes\n" {
+		t.Errorf("Body was %q", res.Body.String())
+	}
+}
@@ -0,0 +1,609 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status codes of responses that are cached
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// How responses of a route are cached
+type cachePolicy struct {
+	ttl time.Duration
+	swr time.Duration
+}
+
+// Option for Route.Cache
+type CacheOption func(*cachePolicy)
+
+// Serves a cached response for up to d after it expires while it is refreshed
+// in the background
+func StaleWhileRevalidate(d time.Duration) CacheOption {
+	return func(p *cachePolicy) {
+		p.swr = d
+	}
+}
+
+// Caches GET and HEAD responses of the route, and of the routes under it
+// unless they set their own, for ttl in Config.Cache. A ttl of zero or less
+// disables caching.
+//
+//	mux.Route("/reports/:id").Named("reports").Cache(30*time.Second, yam.StaleWhileRevalidate(time.Minute)).Get(report)
+//	mux.Config.Cache.Purge("reports")
+//
+// Responses are keyed by method, path, query and the request headers listed
+// in the Vary header of the response. Requests with Cache-Control no-store or
+// an Authorization header bypass the cache, as do requests with a
+// Content-Security-Policy nonce since their response holds it, no-cache and
+// max-age skip stale entries, and only-if-cached is replied to with a 504 on
+// a miss. Responses with Cache-Control no-store, no-cache or private, or
+// setting a cookie, including cookies set by middleware such as a new CSRF
+// token, are not stored, s-maxage replaces the ttl and a shorter max-age reduces it.
+// Successful requests with other methods purge the cached responses of their
+// path.
+func (r *Route) Cache(ttl time.Duration, options ...CacheOption) *Route {
+	p := &cachePolicy{ttl: ttl}
+	for _, option := range options {
+		option(p)
+	}
+	r.cache = p
+
+	return r
+}
+
+// Names the route, cached responses can be purged by name
+func (r *Route) Named(name string) *Route {
+	r.name = name
+
+	return r
+}
+
+// Returns the name of the route, empty if it is not named
+func (r *Route) Name() string {
+	return r.name
+}
+
+// Returns the cache policy for the route, set on the route or inherited from
+// the routes above it, nil if responses are not cached
+func (r *Route) effectiveCache() *cachePolicy {
+	for route := r; route != nil; route = route.parent {
+		if route.cache != nil {
+			if route.cache.ttl <= 0 {
+				return nil
+			}
+			return route.cache
+		}
+	}
+
+	return nil
+}
+
+// An in memory least recently used cache of responses, safe for concurrent
+// use
+type ResponseCache struct {
+	// Responses with a larger body are not cached
+	MaxEntryBytes int
+
+	mu      sync.Mutex
+	size    int
+	ll      *list.List
+	entries map[string]*list.Element
+	vary    map[string]*cacheVary
+	now     func() time.Time
+}
+
+// Request headers a response varies by, counted by the entries using it
+type cacheVary struct {
+	headers []string
+	n       int
+}
+
+// A cached response
+type cacheEntry struct {
+	key     string
+	primary string
+	name    string
+	path    string
+
+	status int
+	header http.Header
+	body   []byte
+	vary   []string // Request headers the response varies by
+
+	stored       time.Time
+	ttl          time.Duration
+	swr          time.Duration
+	revalidating bool
+}
+
+// Constructs a new ResponseCache holding up to size responses, bodies are
+// limited to 1MiB
+func NewResponseCache(size int) *ResponseCache {
+	return &ResponseCache{
+		MaxEntryBytes: 1 << 20,
+		size:          size,
+		ll:            list.New(),
+		entries:       make(map[string]*list.Element),
+		vary:          make(map[string]*cacheVary),
+		now:           time.Now,
+	}
+}
+
+// Returns the number of cached responses
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+// Removes the cached responses of the routes with a name, returning the
+// number removed
+func (c *ResponseCache) Purge(name string) int {
+	return c.purge(func(e *cacheEntry) bool { return e.name == name })
+}
+
+// Removes the cached responses for paths starting with a prefix, returning the
+// number removed
+func (c *ResponseCache) PurgePrefix(prefix string) int {
+	return c.purge(func(e *cacheEntry) bool { return strings.HasPrefix(e.path, prefix) })
+}
+
+func (c *ResponseCache) purge(match func(*cacheEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if match(el.Value.(*cacheEntry)) {
+			c.remove(el)
+			n++
+		}
+		el = next
+	}
+
+	return n
+}
+
+// Removes an entry, the lock must be held
+func (c *ResponseCache) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	if v := c.vary[e.primary]; v != nil {
+		if v.n--; v.n <= 0 {
+			delete(c.vary, e.primary)
+		}
+	}
+}
+
+// Returns the entry for a request, nil if there is none or it is past its
+// stale period
+func (c *ResponseCache) get(primary string, r *http.Request) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var headers []string
+	if v := c.vary[primary]; v != nil {
+		headers = v.headers
+	}
+	el, ok := c.entries[varyKey(primary, headers, r)]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if c.now().Sub(e.stored) > e.ttl+e.swr {
+		c.remove(el)
+		return nil
+	}
+	c.ll.MoveToFront(el)
+
+	return e
+}
+
+// Stores an entry, evicting the least recently used entries over the size
+func (c *ResponseCache) set(e *cacheEntry, vary []string, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if v := c.vary[e.primary]; v != nil && strings.Join(v.headers, ",") != strings.Join(vary, ",") {
+		// The response varies differently now, drop the old variants
+		for el := c.ll.Front(); el != nil; {
+			next := el.Next()
+			if el.Value.(*cacheEntry).primary == e.primary {
+				c.remove(el)
+			}
+			el = next
+		}
+	}
+
+	e.key = varyKey(e.primary, vary, r)
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	if c.vary[e.primary] == nil {
+		c.vary[e.primary] = &cacheVary{headers: vary}
+	}
+	c.vary[e.primary].n++
+	c.entries[e.key] = c.ll.PushFront(e)
+
+	for c.size > 0 && c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Marks an entry as being revalidated, returning false if it already is
+func (c *ResponseCache) revalidate(e *cacheEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e.revalidating {
+		return false
+	}
+	e.revalidating = true
+
+	return true
+}
+
+// Returns the key of a variant of a response
+func varyKey(primary string, headers []string, r *http.Request) string {
+	key := primary
+	for _, h := range headers {
+		key += "\x00" + h + ":" + strings.Join(r.Header.Values(h), ",")
+	}
+
+	return key
+}
+
+// Returns the request headers listed in a Vary header, canonicalized and
+// sorted, and false when the response varies by everything
+func parseVary(values []string) ([]string, bool) {
+	var headers []string
+	seen := map[string]bool{}
+	for _, value := range values {
+		for _, h := range strings.Split(value, ",") {
+			h = http.CanonicalHeaderKey(strings.TrimSpace(h))
+			if h == "*" {
+				return nil, false
+			}
+			if h != "" && !seen[h] {
+				seen[h] = true
+				headers = append(headers, h)
+			}
+		}
+	}
+	sort.Strings(headers)
+
+	return headers, true
+}
+
+// Parsed Cache-Control directives
+type cacheControl struct {
+	directives map[string]string
+}
+
+func parseCacheControl(header string) cacheControl {
+	cc := cacheControl{directives: make(map[string]string)}
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			cc.directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+
+	return cc
+}
+
+// Returns whether a directive is present
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc.directives[name]
+	return ok
+}
+
+// Returns the value of a directive in seconds, false if it is not present or
+// not a number
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := cc.directives[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return time.Duration(n) * time.Second, true
+}
+
+// Serves responses from the cache and stores the responses of the handler
+func cacheHandler(next http.Handler, route *Route, policy *cachePolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := route.yam.Config.Cache
+		if c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			ww := WrapWriter(w)
+			next.ServeHTTP(ww, r)
+			if ww.Status() < 400 {
+				path := r.URL.Path
+				c.purge(func(e *cacheEntry) bool { return e.path == path })
+			}
+			return
+		}
+
+		cc := parseCacheControl(r.Header.Get("Cache-Control"))
+		if cc.has("no-store") || r.Header.Get("Authorization") != "" || CSPNonce(r) != "" {
+			w.Header().Set("Cache-Status", "yam; fwd=bypass")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// HEAD responses are served from GET responses
+		primary := "GET " + r.URL.Path + "?" + r.URL.RawQuery
+		if !cc.has("no-cache") {
+			if e := c.get(primary, r); e != nil {
+				age := c.now().Sub(e.stored)
+				maxAge, limited := cc.seconds("max-age")
+				switch {
+				case age <= e.ttl && (!limited || age <= maxAge):
+					serveCached(w, e, age, "yam; hit")
+					return
+				case age > e.ttl && !limited:
+					if c.revalidate(e) {
+						go refresh(next, route, c, e, r)
+					}
+					serveCached(w, e, age, "yam; hit; detail=stale")
+					return
+				}
+			}
+		}
+
+		if cc.has("only-if-cached") {
+			Error(w, r, NewProblem(http.StatusGatewayTimeout, "the response is not cached"))
+			return
+		}
+
+		if cc.has("no-cache") {
+			w.Header().Set("Cache-Status", "yam; fwd=request")
+		} else {
+			w.Header().Set("Cache-Status", "yam; fwd=miss")
+		}
+		// A HEAD handler of its own does not write the body of the GET
+		// response so it can not be stored
+		if r.Method == http.MethodHead && !route.headFromGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &cacheWriter{ResponseWriter: w, pre: w.Header().Clone(), max: c.MaxEntryBytes}
+		next.ServeHTTP(cw.wrap(), r)
+		cw.store(c, route, policy, primary, r)
+	})
+}
+
+// Writes a cached response
+func serveCached(w http.ResponseWriter, e *cacheEntry, age time.Duration, status string) {
+	h := w.Header()
+	for k, v := range e.header {
+		h[k] = append(h[k], v...)
+	}
+	h.Set("Age", strconv.Itoa(int(age.Seconds())))
+	h.Set("Cache-Status", status)
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// Serves the request again in the background to refresh a stale entry
+func refresh(next http.Handler, route *Route, c *ResponseCache, e *cacheEntry, r *http.Request) {
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	req.Method = http.MethodGet
+	for _, k := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"} {
+		req.Header.Del(k)
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			if v != http.ErrAbortHandler && route.yam.Config.PanicHandler != nil {
+				route.yam.Config.PanicHandler(req, &Panic{Value: v, Stack: debug.Stack(), Pattern: route.Pattern()})
+			}
+			// Let a later request try again
+			c.mu.Lock()
+			e.revalidating = false
+			c.mu.Unlock()
+		}
+	}()
+
+	// The Vary header set by middleware outside the handler is kept
+	header := http.Header{}
+	if len(e.vary) > 0 {
+		header.Set("Vary", strings.Join(e.vary, ", "))
+	}
+	cw := &cacheWriter{ResponseWriter: discardWriter{header}, pre: header.Clone(), max: c.MaxEntryBytes}
+	next.ServeHTTP(cw, req)
+	policy := &cachePolicy{ttl: e.ttl, swr: e.swr}
+	if !cw.store(c, route, policy, e.primary, req) {
+		c.mu.Lock()
+		e.revalidating = false
+		c.mu.Unlock()
+	}
+}
+
+// Copies a response as it is written so it can be cached
+type cacheWriter struct {
+	http.ResponseWriter
+	pre    http.Header // Header before the handler ran
+	header http.Header // Header set by the handler
+	vary   []string    // Vary header of the response, including middleware's
+	status int
+	body   []byte
+	max    int
+	skip   bool // The response is not cacheable
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+		w.header = headerDiff(w.pre, w.Header())
+		w.vary = append([]string(nil), w.Header().Values("Vary")...)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.skip {
+		if len(w.body)+len(b) > w.max {
+			w.skip = true
+			w.body = nil
+		} else {
+			w.body = append(w.body, b...)
+		}
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Stores the response if it is cacheable, returning whether it was
+func (w *cacheWriter) store(c *ResponseCache, route *Route, policy *cachePolicy, primary string, r *http.Request) bool {
+	if w.skip {
+		return false
+	}
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	// The cookie may have been set by middleware, with the body depending on
+	// it like a CSRF token
+	if !cacheableStatus[w.status] || w.Header().Get("Set-Cookie") != "" {
+		return false
+	}
+
+	cc := parseCacheControl(strings.Join(w.header.Values("Cache-Control"), ","))
+	if cc.has("no-store") || cc.has("no-cache") || cc.has("private") {
+		return false
+	}
+	// Middleware may vary the response too, for example by Cookie
+	vary, ok := parseVary(w.vary)
+	if !ok {
+		return false
+	}
+
+	ttl, swr := policy.ttl, policy.swr
+	if d, ok := cc.seconds("s-maxage"); ok {
+		ttl = d
+	} else if d, ok := cc.seconds("max-age"); ok && d < ttl {
+		ttl = d
+	}
+	if d, ok := cc.seconds("stale-while-revalidate"); ok {
+		swr = d
+	}
+	if ttl <= 0 {
+		return false
+	}
+
+	header := w.header.Clone()
+	if header.Get("Content-Type") == "" && len(w.body) > 0 && w.Header().Get("Content-Type") == "" {
+		// net/http sniffed the body when it was written
+		header.Set("Content-Type", http.DetectContentType(w.body))
+	}
+	header.Del("Age")
+	header.Del("Cache-Status")
+	c.set(&cacheEntry{
+		primary: primary,
+		name:    route.name,
+		path:    r.URL.Path,
+		status:  w.status,
+		header:  header,
+		body:    w.body,
+		vary:    vary,
+		stored:  c.now(),
+		ttl:     ttl,
+		swr:     swr,
+	}, vary, r)
+
+	return true
+}
+
+func (w *cacheWriter) Flush() {
+	// Streamed responses are not cached
+	w.skip = true
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *cacheWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.skip = true
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// Returns the underlying writer for http.ResponseController
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Returns the writer implementing http.Flusher and http.Hijacker when the
+// underlying writer does
+func (w *cacheWriter) wrap() http.ResponseWriter {
+	_, isFlusher := w.ResponseWriter.(http.Flusher)
+	_, isHijacker := w.ResponseWriter.(http.Hijacker)
+
+	switch {
+	case isFlusher && isHijacker:
+		return w
+	case isFlusher:
+		return struct {
+			unwrapper
+			http.Flusher
+		}{w, w}
+	case isHijacker:
+		return struct {
+			unwrapper
+			http.Hijacker
+		}{w, w}
+	}
+
+	return struct{ unwrapper }{w}
+}
+
+// Returns the header values in post that were not in pre
+func headerDiff(pre, post http.Header) http.Header {
+	diff := make(http.Header)
+	for k, values := range post {
+		old := pre[k]
+		if len(old) <= len(values) && strings.Join(old, "\x00") == strings.Join(values[:len(old)], "\x00") {
+			values = values[len(old):]
+		}
+		if len(values) > 0 {
+			diff[k] = append([]string(nil), values...)
+		}
+	}
+
+	return diff
+}
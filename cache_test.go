@@ -0,0 +1,316 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A clock for the cache that only moves when told to
+type cacheClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *cacheClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *cacheClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newCacheMux() (*Yam, *cacheClock, *int32) {
+	clock := &cacheClock{now: time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)}
+	var calls int32
+
+	mux := New()
+	mux.Config.Cache.now = clock.Now
+	counter := func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if cc := r.URL.Query().Get("cc"); cc != "" {
+			w.Header().Set("Cache-Control", cc)
+		}
+		if r.URL.Query().Get("cookie") != "" {
+			w.Header().Set("Set-Cookie", "a=b")
+		}
+		w.Write([]byte(strconv.Itoa(int(n))))
+	}
+	mux.Route("/reports").Named("reports").Cache(30 * time.Second).
+		Get(http.HandlerFunc(counter)).
+		Post(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	mux.Route("/reports/:id").Cache(30 * time.Second).Get(http.HandlerFunc(counter))
+	mux.Route("/lang").Cache(30 * time.Second).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+	mux.Route("/stale").Cache(30*time.Second, StaleWhileRevalidate(time.Minute)).Get(http.HandlerFunc(counter))
+	mux.Route("/missing").Cache(30 * time.Second).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	return mux, clock, &calls
+}
+
+func cacheGet(mux *Yam, method, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for i := 0; i < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	return res
+}
+
+func TestCache(t *testing.T) {
+	mux, clock, calls := newCacheMux()
+
+	res := cacheGet(mux, "GET", "/reports")
+	if res.Body.String() != "1" || res.Header().Get("Cache-Status") != "yam; fwd=miss" {
+		t.Fatalf("Body was %q with Cache-Status %q, should be a miss", res.Body.String(), res.Header().Get("Cache-Status"))
+	}
+
+	clock.Add(10 * time.Second)
+	res = cacheGet(mux, "GET", "/reports")
+	if res.Body.String() != "1" || res.Header().Get("Cache-Status") != "yam; hit" {
+		t.Errorf("Body was %q with Cache-Status %q, should be a hit", res.Body.String(), res.Header().Get("Cache-Status"))
+	}
+	if res.Header().Get("Age") != "10" {
+		t.Errorf("Age was %q, should be %q", res.Header().Get("Age"), "10")
+	}
+	if res.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type was %q", res.Header().Get("Content-Type"))
+	}
+
+	res = cacheGet(mux, "HEAD", "/reports")
+	if res.Header().Get("Cache-Status") != "yam; hit" || res.Body.Len() != 0 {
+		t.Errorf("HEAD Cache-Status was %q, should be served from the GET response", res.Header().Get("Cache-Status"))
+	}
+
+	var tests = []struct {
+		name   string
+		path   string
+		header []string
+		body   string
+	}{
+		{"query", "/reports?page=2", nil, "2"},
+		{"query cached", "/reports?page=2", nil, "2"},
+		{"params", "/reports/1", nil, "3"},
+		{"no-store", "/reports", []string{"Cache-Control", "no-store"}, "4"},
+		{"authorization", "/reports", []string{"Authorization", "Bearer x"}, "5"},
+		{"cached", "/reports", nil, "1"},
+		{"no-cache", "/reports", []string{"Cache-Control", "no-cache"}, "6"},
+		{"refreshed", "/reports", nil, "6"},
+		{"max-age", "/reports", []string{"Cache-Control", "max-age=0"}, "7"},
+		{"response no-store", "/reports?cc=no-store", nil, "8"},
+		{"response no-store again", "/reports?cc=no-store", nil, "9"},
+		{"response private", "/reports?cc=private", nil, "10"},
+		{"response private again", "/reports?cc=private", nil, "11"},
+		{"cookie", "/reports?cookie=1", nil, "12"},
+		{"cookie again", "/reports?cookie=1", nil, "13"},
+	}
+
+	for _, test := range tests {
+		clock.Add(time.Second)
+		res := cacheGet(mux, "GET", test.path, test.header...)
+		if res.Body.String() != test.body {
+			t.Errorf("%s: Body was %q, should be %q", test.name, res.Body.String(), test.body)
+		}
+	}
+
+	clock.Add(30 * time.Second)
+	if res := cacheGet(mux, "GET", "/reports"); res.Body.String() != "14" {
+		t.Errorf("Body was %q, expired responses should not be served", res.Body.String())
+	}
+
+	res = cacheGet(mux, "GET", "/reports/2", "Cache-Control", "only-if-cached")
+	if res.Code != http.StatusGatewayTimeout {
+		t.Errorf("only-if-cached status was %d, should be %d", res.Code, http.StatusGatewayTimeout)
+	}
+
+	cacheGet(mux, "GET", "/missing")
+	cacheGet(mux, "GET", "/missing")
+	if atomic.LoadInt32(calls) != 16 {
+		t.Errorf("Handler was called %d times, errors should not be cached", atomic.LoadInt32(calls))
+	}
+}
+
+func TestCacheResponseMaxAge(t *testing.T) {
+	mux, clock, _ := newCacheMux()
+
+	cacheGet(mux, "GET", "/reports?cc=max-age=5")
+	clock.Add(6 * time.Second)
+	if res := cacheGet(mux, "GET", "/reports?cc=max-age=5"); res.Body.String() != "2" {
+		t.Errorf("Body was %q, max-age should shorten the ttl", res.Body.String())
+	}
+
+	cacheGet(mux, "GET", "/reports?cc=s-maxage=60")
+	clock.Add(45 * time.Second)
+	if res := cacheGet(mux, "GET", "/reports?cc=s-maxage=60"); res.Body.String() != "3" {
+		t.Errorf("Body was %q, s-maxage should replace the ttl", res.Body.String())
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	mux, _, calls := newCacheMux()
+
+	for _, lang := range []string{"en", "fr", "en", "fr"} {
+		res := cacheGet(mux, "GET", "/lang", "Accept-Language", lang)
+		if res.Body.String() != lang {
+			t.Errorf("Body was %q, should be %q", res.Body.String(), lang)
+		}
+	}
+	if atomic.LoadInt32(calls) != 2 {
+		t.Errorf("Handler was called %d times, should be once per language", atomic.LoadInt32(calls))
+	}
+}
+
+func TestCacheHead(t *testing.T) {
+	mux, _, _ := newCacheMux()
+	mux.Route("/sized").Cache(30 * time.Second).
+		Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("body"))
+		})).
+		Head(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "4")
+		}))
+
+	// A HEAD handler of its own is not stored
+	cacheGet(mux, "HEAD", "/sized")
+	res := cacheGet(mux, "GET", "/sized")
+	if res.Body.String() != "body" || res.Header().Get("Cache-Status") != "yam; fwd=miss" {
+		t.Errorf("Body was %q with Cache-Status %q, should be a miss", res.Body.String(), res.Header().Get("Cache-Status"))
+	}
+
+	// HEAD served by the GET handler is stored for GET
+	cacheGet(mux, "HEAD", "/reports")
+	res = cacheGet(mux, "GET", "/reports")
+	if res.Body.String() != "1" || res.Header().Get("Cache-Status") != "yam; hit" {
+		t.Errorf("Body was %q with Cache-Status %q, should be a hit", res.Body.String(), res.Header().Get("Cache-Status"))
+	}
+}
+
+func TestCacheMiddlewareVary(t *testing.T) {
+	mux := New()
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Cookie")
+			next.ServeHTTP(w, r)
+		})
+	})
+	mux.Route("/me").Cache(time.Minute).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := r.Cookie("user")
+		w.Write([]byte("hello " + c.Value))
+	}))
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		res := cacheGet(mux, "GET", "/me", "Cookie", "user="+user)
+		if res.Body.String() != "hello "+user {
+			t.Errorf("Body was %q with Cache-Status %q, should be %q", res.Body.String(), res.Header().Get("Cache-Status"), "hello "+user)
+		}
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	mux, clock, calls := newCacheMux()
+
+	cacheGet(mux, "GET", "/stale")
+	clock.Add(45 * time.Second)
+
+	res := cacheGet(mux, "GET", "/stale")
+	if res.Body.String() != "1" || res.Header().Get("Cache-Status") != "yam; hit; detail=stale" {
+		t.Errorf("Body was %q with Cache-Status %q, should be stale", res.Body.String(), res.Header().Get("Cache-Status"))
+	}
+
+	// The refreshed response replaces the stale one once it is stored
+	for i := 0; i < 100; i++ {
+		res = cacheGet(mux, "GET", "/stale")
+		if res.Body.String() == "2" {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if res.Body.String() != "2" || res.Header().Get("Cache-Status") != "yam; hit" {
+		t.Errorf("Body was %q with Cache-Status %q, should be refreshed", res.Body.String(), res.Header().Get("Cache-Status"))
+	}
+	if atomic.LoadInt32(calls) != 2 {
+		t.Errorf("Handler was called %d times, stale responses should be refreshed once", atomic.LoadInt32(calls))
+	}
+
+	clock.Add(2 * time.Minute)
+	if res := cacheGet(mux, "GET", "/stale"); res.Body.String() != "3" {
+		t.Errorf("Body was %q, responses past the stale period should not be served", res.Body.String())
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	mux, _, _ := newCacheMux()
+	c := mux.Config.Cache
+
+	cacheGet(mux, "GET", "/reports")
+	cacheGet(mux, "GET", "/reports?page=2")
+	cacheGet(mux, "GET", "/reports/1")
+	cacheGet(mux, "GET", "/reports/2")
+	if c.Len() != 4 {
+		t.Fatalf("Cache had %d entries, should have 4", c.Len())
+	}
+
+	if n := c.Purge("reports"); n != 2 {
+		t.Errorf("Purge removed %d entries, should remove 2", n)
+	}
+	if n := c.PurgePrefix("/reports/1"); n != 1 {
+		t.Errorf("PurgePrefix removed %d entries, should remove 1", n)
+	}
+
+	cacheGet(mux, "GET", "/reports")
+	cacheGet(mux, "POST", "/reports")
+	if c.Len() != 1 {
+		t.Errorf("Cache had %d entries, unsafe requests should purge their path", c.Len())
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	mux, _, calls := newCacheMux()
+	mux.Config.Cache = NewResponseCache(2)
+
+	for _, path := range []string{"/reports/1", "/reports/2", "/reports/1", "/reports/3", "/reports/1", "/reports/2"} {
+		cacheGet(mux, "GET", path)
+	}
+	if atomic.LoadInt32(calls) != 4 {
+		t.Errorf("Handler was called %d times, the least recently used response should be evicted", atomic.LoadInt32(calls))
+	}
+	if mux.Config.Cache.Len() != 2 {
+		t.Errorf("Cache had %d entries, should have 2", mux.Config.Cache.Len())
+	}
+}
+
+func TestCacheRequestID(t *testing.T) {
+	mux, _, _ := newCacheMux()
+	mux.Config.RequestID = true
+
+	first := cacheGet(mux, "GET", "/reports").Header().Get("X-Request-ID")
+	second := cacheGet(mux, "GET", "/reports")
+	if second.Header().Get("Cache-Status") != "yam; hit" {
+		t.Fatalf("Cache-Status was %q, should be a hit", second.Header().Get("Cache-Status"))
+	}
+	if ids := second.Header().Values("X-Request-ID"); len(ids) != 1 || ids[0] == first {
+		t.Errorf("X-Request-ID was %q, headers of the first request should not be cached", ids)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Returns the IP address of the client. When the request came through
+// proxies in Config.TrustedProxies it is read from the Forwarded or
+// X-Forwarded-For header, otherwise it is the address of the connection.
+// Middleware wrapping the mux can read it after the mux has served the
+// request when the request was prepared with WithRouteInfo.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	if info, ok := r.Context().Value(routeKey).(*RouteInfo); ok && info.clientIP != "" {
+		return info.clientIP
+	}
+
+	return remoteHost(r)
+}
+
+// Returns the host of the remote address of the request
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Resolves the IP address of the client. Forwarded addresses are walked from
+// the nearest hop, the client is the first address not in a trusted range,
+// or the furthest address when all of them are trusted.
+func (y *Yam) clientIP(r *http.Request) string {
+	remote := remoteHost(r)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !y.trustedProxy(addr) {
+		return remote
+	}
+
+	if _, client := y.clientHop(forwarded(r.Header)); client.IsValid() {
+		addr = client
+	}
+
+	return addr.String()
+}
+
+// Returns the index and address of the client among forwarded hops, walking
+// from the nearest hop until an address not in a trusted range. The index is
+// the number of hops and the address invalid when no hop has an address.
+func (y *Yam) clientHop(hops []forwardedHop) (int, netip.Addr) {
+	client, addr := len(hops), netip.Addr{}
+	for i := len(hops) - 1; i >= 0; i-- {
+		a, err := netip.ParseAddr(hops[i].addr)
+		if err != nil {
+			break
+		}
+		client, addr = i, a.Unmap()
+		if !y.trustedProxy(addr) {
+			break
+		}
+	}
+
+	return client, addr
+}
+
+// Reports whether an address is in one of the trusted proxy ranges
+func (y *Yam) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range y.Config.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether the request came directly from a trusted proxy, so its
+// forwarding headers can be believed
+func (y *Yam) fromTrustedProxy(r *http.Request) bool {
+	addr, err := netip.ParseAddr(remoteHost(r))
+
+	return err == nil && y.trustedProxy(addr)
+}
+
+// A hop of a forwarded request, the address of the client of a proxy and the
+// protocol it used
+type forwardedHop struct {
+	addr  string
+	proto string
+}
+
+// Returns the forwarded hops of the request, furthest first, from the
+// Forwarded header or X-Forwarded-For and X-Forwarded-Proto when there is
+// none. X-Forwarded-Proto values are matched to the hops from the nearest,
+// hops left without an address have an empty one.
+func forwarded(h http.Header) []forwardedHop {
+	var hops []forwardedHop
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				var hop forwardedHop
+				for _, pair := range strings.Split(element, ";") {
+					k, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					switch {
+					case strings.EqualFold(k, "for"):
+						hop.addr = hostOnly(v)
+					case strings.EqualFold(k, "proto"):
+						hop.proto = strings.ToLower(strings.Trim(v, `"`))
+					}
+				}
+				if hop != (forwardedHop{}) {
+					hops = append(hops, hop)
+				}
+			}
+		}
+		return hops
+	}
+
+	var addrs, protos []string
+	for _, value := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			addrs = append(addrs, hostOnly(hop))
+		}
+	}
+	for _, value := range h.Values("X-Forwarded-Proto") {
+		for _, proto := range strings.Split(value, ",") {
+			protos = append(protos, strings.ToLower(strings.TrimSpace(proto)))
+		}
+	}
+	hops = make([]forwardedHop, max(len(addrs), len(protos)))
+	for i := range addrs {
+		hops[len(hops)-len(addrs)+i].addr = addrs[i]
+	}
+	for i := range protos {
+		hops[len(hops)-len(protos)+i].proto = protos[i]
+	}
+
+	return hops
+}
+
+// Strips quotes, brackets and the port from a forwarded address
+func hostOnly(s string) string {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if strings.HasPrefix(s, "[") {
+		if i := strings.IndexByte(s, ']'); i > 0 {
+			return s[1:i]
+		}
+		return s
+	}
+	if strings.Count(s, ":") == 1 {
+		return s[:strings.IndexByte(s, ':')]
+	}
+
+	return s
+}
+
+// Allows only clients with an IP address in one of the ranges to reach the
+// route and the routes under it, other clients are replied to with a 403
+// Forbidden through the error handler. Allow lists of parent routes also
+// apply, so a client must be in a range of each of them.
+//
+//	mux.Route("/admin").AllowIPs(netip.MustParsePrefix("203.0.113.0/24"), netip.MustParsePrefix("10.8.0.0/16"))
+func (r *Route) AllowIPs(ranges ...netip.Prefix) *Route {
+	r.allowIPs = append(r.allowIPs, ranges...)
+
+	return r
+}
+
+// Refuses clients with an IP address in one of the ranges on the route and
+// the routes under it with a 403 Forbidden through the error handler
+func (r *Route) DenyIPs(ranges ...netip.Prefix) *Route {
+	r.denyIPs = append(r.denyIPs, ranges...)
+
+	return r
+}
+
+// Wraps a handler so it is only reached by clients allowed by the IP lists
+// of the route and the routes above it
+func (r *Route) ipFilter(next http.Handler) http.Handler {
+	var allow [][]netip.Prefix
+	var deny []netip.Prefix
+	for route := r; route != nil; route = route.parent {
+		if len(route.allowIPs) > 0 {
+			allow = append(allow, route.allowIPs)
+		}
+		deny = append(deny, route.denyIPs...)
+	}
+	if len(allow) == 0 && len(deny) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		addr, err := netip.ParseAddr(ClientIP(req))
+		if err != nil || !ipAllowed(addr.Unmap(), allow, deny) {
+			Error(w, req, NewProblem(http.StatusForbidden, "the client IP address is not allowed"))
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Reports whether an address is in none of the denied ranges and in each of
+// the allow lists
+func ipAllowed(addr netip.Addr, allow [][]netip.Prefix, deny []netip.Prefix) bool {
+	if contains(deny, addr) {
+		return false
+	}
+	for _, list := range allow {
+		if !contains(list, addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reports whether an address is in one of the ranges
+func contains(ranges []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range ranges {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
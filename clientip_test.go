@@ -0,0 +1,125 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	var ip string
+
+	mux := New()
+	mux.Config.TrustedProxies = []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("fd00::/8"),
+	}
+	mux.Route("/").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip = ClientIP(r)
+	}))
+
+	var tests = []struct {
+		remote    string
+		forwarded string
+		xff       string
+		ip        string
+	}{
+		{"192.0.2.1:1234", "", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "", "198.51.100.1", "192.0.2.1"},
+		{"10.0.0.1:1234", "", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.1:1234", "", "203.0.113.9, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"10.0.0.1:1234", "", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"10.0.0.1:1234", "", "198.51.100.1, nonsense", "10.0.0.1"},
+		{"10.0.0.1:1234", "", "198.51.100.1:5678", "198.51.100.1"},
+		{"10.0.0.1:1234", `for=192.0.2.60;proto=https, for="[2001:db8::1]:4711"`, "203.0.113.9", "2001:db8::1"},
+		{"10.0.0.1:1234", "for=192.0.2.60, for=10.0.0.9", "", "192.0.2.60"},
+		{"10.0.0.1:1234", "for=unknown", "", "10.0.0.1"},
+		{"[fd00::1]:1234", "", "2001:db8::2", "2001:db8::2"},
+		{"[::ffff:10.0.0.1]:1234", "", "198.51.100.1", "198.51.100.1"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			req.Header.Set("Forwarded", test.forwarded)
+		}
+		if test.xff != "" {
+			req.Header.Set("X-Forwarded-For", test.xff)
+		}
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+
+		if ip != test.ip {
+			t.Errorf("%s %q %q: Client IP was %s, should be %s", test.remote, test.forwarded, test.xff, ip, test.ip)
+		}
+	}
+}
+
+func TestClientIPWrapped(t *testing.T) {
+	mux := New()
+	mux.Config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	req := httptest.NewRequest("GET", "/nope", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if ip := ClientIP(req); ip != "10.0.0.1" {
+		t.Errorf("Client IP was %s, should be 10.0.0.1 before serving", ip)
+	}
+
+	req = WithRouteInfo(req)
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	if ip := ClientIP(req); ip != "198.51.100.1" {
+		t.Errorf("Client IP was %s, should be 198.51.100.1 after serving", ip)
+	}
+}
+
+func TestAllowIPs(t *testing.T) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mux := New()
+	mux.Config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	admin := mux.Route("/admin").AllowIPs(netip.MustParsePrefix("203.0.113.0/24"), netip.MustParsePrefix("2001:db8::/32")).Get(fn)
+	admin.Route("/billing").AllowIPs(netip.MustParsePrefix("203.0.113.0/28")).Get(fn)
+	admin.Route("/users").DenyIPs(netip.MustParsePrefix("203.0.113.66/32")).Get(fn)
+	mux.Route("/public").DenyIPs(netip.MustParsePrefix("198.51.100.0/24")).Get(fn)
+
+	var tests = []struct {
+		path   string
+		remote string
+		xff    string
+		status int
+	}{
+		{"/admin", "203.0.113.66:1", "", http.StatusOK},
+		{"/admin", "[2001:db8::1]:1", "", http.StatusOK},
+		{"/admin", "192.0.2.1:1", "", http.StatusForbidden},
+		{"/admin", "10.0.0.1:1", "203.0.113.66", http.StatusOK},
+		{"/admin", "10.0.0.1:1", "192.0.2.1", http.StatusForbidden},
+		{"/admin", "192.0.2.1:1", "203.0.113.66", http.StatusForbidden},
+		{"/admin/billing", "203.0.113.2:1", "", http.StatusOK},
+		{"/admin/billing", "203.0.113.66:1", "", http.StatusForbidden},
+		{"/admin/users", "203.0.113.65:1", "", http.StatusOK},
+		{"/admin/users", "203.0.113.66:1", "", http.StatusForbidden},
+		{"/public", "192.0.2.1:1", "", http.StatusOK},
+		{"/public", "198.51.100.7:1", "", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.RemoteAddr = test.remote
+		if test.xff != "" {
+			req.Header.Set("X-Forwarded-For", test.xff)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s from %s %q: Status was %d, should be %d", test.path, test.remote, test.xff, w.Code, test.status)
+		}
+	}
+}
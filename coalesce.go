@@ -0,0 +1,210 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Request headers that make otherwise identical requests distinct, responses
+// are never shared between users
+var coalesceHeaders = []string{"Accept", "Accept-Language", "Authorization", "Cookie"}
+
+// Sets whether concurrent identical GET and HEAD requests to the route, and to
+// the routes under it unless they set their own, share one execution of the
+// handler. Requests are identical when their path, query and the Accept,
+// Accept-Language, Authorization and Cookie headers are.
+//
+//	mux.Route("/catalog/:id").Coalesce(true).Get(product)
+//
+// The first request runs the handler as a GET request, its response is
+// buffered and replayed to every request waiting on it, including HEAD
+// requests. Waiting requests whose headers listed in the Vary header of the
+// response differ run the handler themselves. Panics in the handler are
+// re-raised in every waiting request. Requests with a Content-Security-Policy
+// nonce, or given a cookie by middleware such as a new CSRF token, are not
+// coalesced since their response is their own.
+//
+// The handler runs with a context that is not cancelled when the first
+// request is, so a client disconnecting does not fail the requests waiting on
+// it. The context is cancelled once every waiting request has been cancelled,
+// a request that is cancelled stops waiting and returns without a response.
+func (r *Route) Coalesce(enabled bool) *Route {
+	r.coalesce = &coalescer{enabled: enabled, calls: make(map[string]*coalesceCall)}
+
+	return r
+}
+
+// Returns the coalescer for the route, set on the route or inherited from the
+// routes above it, nil if requests are not coalesced
+func (r *Route) effectiveCoalescer() *coalescer {
+	for route := r; route != nil; route = route.parent {
+		if route.coalesce != nil {
+			if !route.coalesce.enabled {
+				return nil
+			}
+			return route.coalesce
+		}
+	}
+
+	return nil
+}
+
+// Tracks the handler executions in flight for a route and the routes under it
+type coalescer struct {
+	enabled bool
+
+	mu    sync.Mutex
+	calls map[string]*coalesceCall
+}
+
+// A handler execution shared by identical requests
+type coalesceCall struct {
+	req    *http.Request
+	done   chan struct{}
+	cancel context.CancelFunc
+	refs   int // Requests waiting on the call
+
+	pre      http.Header // Header before the handler ran
+	w        *recordWriter
+	panicked interface{}
+}
+
+// Middleware sharing handler executions between identical requests
+func (g *coalescer) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if CSPNonce(r) != "" || w.Header().Get("Set-Cookie") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := varyKey(r.URL.Path+"?"+r.URL.RawQuery, coalesceHeaders, r)
+
+		g.mu.Lock()
+		c, ok := g.calls[key]
+		if !ok {
+			ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+			req := r.Clone(ctx)
+			req.Method = http.MethodGet
+			c = &coalesceCall{
+				req:    req,
+				done:   make(chan struct{}),
+				cancel: cancel,
+				pre:    w.Header().Clone(),
+				w:      &recordWriter{header: w.Header().Clone()},
+			}
+			g.calls[key] = c
+			go g.do(key, c, next)
+		}
+		c.refs++
+		g.mu.Unlock()
+
+		select {
+		case <-c.done:
+		case <-r.Context().Done():
+			g.mu.Lock()
+			if c.refs--; c.refs == 0 {
+				// Nobody is waiting on the response anymore
+				c.cancel()
+				if g.calls[key] == c {
+					delete(g.calls, key)
+				}
+			}
+			g.mu.Unlock()
+			return
+		}
+
+		if c.panicked != nil {
+			// Re-panic on the serving goroutine so it can be recovered
+			panic(c.panicked)
+		}
+
+		if !sameVary(c.w.header.Values("Vary"), c.req, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The call is done so its response is no longer written to
+		dst := w.Header()
+		for k, v := range headerDiff(c.pre, c.w.header) {
+			dst[k] = append(dst[k], v...)
+		}
+		status := c.w.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		w.Write(c.w.body.Bytes())
+	})
+}
+
+// Runs the handler for a call
+func (g *coalescer) do(key string, c *coalesceCall, next http.Handler) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.panicked = p
+		}
+
+		g.mu.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		c.cancel()
+		close(c.done)
+	}()
+
+	next.ServeHTTP(c.w, c.req)
+}
+
+// Returns whether two requests have the same values for the headers listed in
+// a Vary header
+func sameVary(vary []string, a, b *http.Request) bool {
+	headers, ok := parseVary(vary)
+	if !ok {
+		return false
+	}
+	for _, h := range headers {
+		if strings.Join(a.Header.Values(h), ",") != strings.Join(b.Header.Values(h), ",") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Records the response of a shared call so it can be written to every request
+// waiting on it
+type recordWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (w *recordWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *recordWriter) WriteHeader(code int) {
+	if w.status != 0 || (code >= 100 && code < 200) {
+		return
+	}
+	w.status = code
+}
@@ -0,0 +1,299 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Waits until n requests are waiting on calls of the coalescer
+func waitRefs(t *testing.T, g *coalescer, n int) {
+	for i := 0; i < 1000; i++ {
+		g.mu.Lock()
+		refs := 0
+		for _, c := range g.calls {
+			refs += c.refs
+		}
+		g.mu.Unlock()
+		if refs == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d requests were not waiting", n)
+}
+
+func TestCoalesce(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	mux := New()
+	route := mux.Route("/catalog/:id").Coalesce(true).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		<-release
+		if r.Method != "GET" {
+			t.Errorf("Method was %q, the shared execution should be a GET", r.Method)
+		}
+		w.Header().Set("X-Calls", strconv.Itoa(int(n)))
+		w.Write([]byte("product " + r.URL.Query().Get(":id")))
+	}))
+	g := route.effectiveCoalescer()
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 10)
+	for i := range results {
+		method := "GET"
+		if i%2 == 1 {
+			method = "HEAD"
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = httptest.NewRecorder()
+			mux.ServeHTTP(results[i], httptest.NewRequest(method, "/catalog/1", nil))
+		}(i)
+	}
+	waitRefs(t, g, 10)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Handler was called %d times, should be called once", calls)
+	}
+	for i, res := range results {
+		if res.Header().Values("X-Calls")[0] != "1" || len(res.Header().Values("X-Calls")) != 1 {
+			t.Errorf("Request %d: X-Calls was %q, should be replayed once", i, res.Header().Values("X-Calls"))
+		}
+		body := "product 1"
+		if i%2 == 1 {
+			body = ""
+		}
+		if res.Body.String() != body {
+			t.Errorf("Request %d: Body was %q, should be %q", i, res.Body.String(), body)
+		}
+	}
+
+	// Requests after the call has finished run the handler again
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/catalog/1", nil))
+	if calls != 2 {
+		t.Errorf("Handler was called %d times, should be called again", calls)
+	}
+}
+
+func TestCoalesceDistinct(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	mux := New()
+	route := mux.Route("/api").Coalesce(true)
+	route.Route("/users").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("Vary", "X-Tenant")
+		w.Write([]byte(r.Header.Get("Authorization") + r.Header.Get("X-Tenant")))
+	}))
+	route.Route("/raw").Coalesce(false).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+	}))
+	g := route.effectiveCoalescer()
+
+	var tests = []struct {
+		path   string
+		auth   string
+		tenant string
+		body   string
+	}{
+		{"/api/users", "a", "", "a"},
+		{"/api/users", "b", "", "b"},
+		{"/api/users", "a", "", "a"},
+		{"/api/users?page=2", "a", "", "a"},
+		{"/api/users", "a", "x", "ax"},
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, len(tests))
+	for i, test := range tests {
+		wg.Add(1)
+		go func(i int, path, auth, tenant string) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Authorization", auth)
+			if tenant != "" {
+				req.Header.Set("X-Tenant", tenant)
+			}
+			results[i] = httptest.NewRecorder()
+			mux.ServeHTTP(results[i], req)
+		}(i, test.path, test.auth, test.tenant)
+		if i == 0 {
+			// The request without a tenant leads the call
+			waitRefs(t, g, 1)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/raw", nil))
+		}()
+	}
+	waitRefs(t, g, len(tests))
+	close(release)
+	wg.Wait()
+
+	for i, test := range tests {
+		if results[i].Body.String() != test.body {
+			t.Errorf("%s %s %s: Body was %q, should be %q", test.path, test.auth, test.tenant, results[i].Body.String(), test.body)
+		}
+	}
+	// Two users, the second page and the tenant varying from the first user,
+	// plus the two uncoalesced requests
+	if calls != 6 {
+		t.Errorf("Handler was called %d times, should be called 6 times", calls)
+	}
+}
+
+func TestCoalescePanic(t *testing.T) {
+	release := make(chan struct{})
+	mux := New()
+	mux.Config.Recover = true
+	mux.Config.PanicHandler = func(*http.Request, *Panic) {}
+	route := mux.Route("/").Coalesce(true).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		panic("oops")
+	}))
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = httptest.NewRecorder()
+			mux.ServeHTTP(results[i], httptest.NewRequest("GET", "/", nil))
+		}(i)
+	}
+	waitRefs(t, route.effectiveCoalescer(), 3)
+	close(release)
+	wg.Wait()
+
+	for i, res := range results {
+		if res.Code != http.StatusInternalServerError {
+			t.Errorf("Request %d: Status was %d, should be %d", i, res.Code, http.StatusInternalServerError)
+		}
+	}
+}
+
+func TestCoalesceCancel(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	cancelled := make(chan error, 1)
+	mux := New()
+	route := mux.Route("/").Coalesce(true).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-release:
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+		}
+	}))
+	mux.Route("/slow").Coalesce(true).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+		cancelled <- r.Context().Err()
+	}))
+	g := route.effectiveCoalescer()
+
+	// The leader going away does not cancel the execution others wait on
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(leaderCtx))
+		close(leaderDone)
+	}()
+	<-started
+	waitRefs(t, g, 1)
+
+	follower := httptest.NewRecorder()
+	followerDone := make(chan struct{})
+	go func() {
+		mux.ServeHTTP(follower, httptest.NewRequest("GET", "/", nil))
+		close(followerDone)
+	}()
+	waitRefs(t, g, 2)
+
+	cancelLeader()
+	<-leaderDone
+	close(release)
+	<-followerDone
+
+	if follower.Body.String() != "done" {
+		t.Errorf("Body was %q, the follower should get the response", follower.Body.String())
+	}
+
+	// The execution is cancelled once every request has gone away
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil).WithContext(ctx))
+		close(done)
+	}()
+	<-started
+	cancel()
+	<-done
+
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Errorf("Context error was %v, should be %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Error("Handler context should be cancelled when every request has gone away")
+	}
+}
+
+func TestCoalesceNonce(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := New()
+	mux.Config.SecurityHeaders = &SecurityHeaders{ContentSecurityPolicy: "script-src 'nonce-{nonce}'"}
+	mux.Route("/").Coalesce(true).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte(CSPNonce(r)))
+	}))
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = httptest.NewRecorder()
+			mux.ServeHTTP(results[i], httptest.NewRequest("GET", "/", nil))
+		}(i)
+	}
+	for range results {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Error("Requests with a nonce should not be coalesced")
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	for i, res := range results {
+		if csp := res.Header().Get("Content-Security-Policy"); csp != "script-src 'nonce-"+res.Body.String()+"'" {
+			t.Errorf("Request %d: Body was %q, should be the nonce of %q", i, res.Body.String(), csp)
+		}
+	}
+}
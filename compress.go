@@ -0,0 +1,362 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Default content types that are not compressed, matched by prefix, as they
+// are compressed already
+var DefaultCompressionDenylist = []string{
+	"image/",
+	"audio/",
+	"video/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/vnd.rar",
+	"application/octet-stream",
+}
+
+// Compressed content types that are worth compressing regardless of the
+// denylist prefixes above
+var compressibleImages = []string{"image/svg+xml"}
+
+// Creates a compressing writer for a Content-Encoding
+type EncoderFunc func(io.Writer) io.WriteCloser
+
+// Compresses responses with an encoding negotiated with the Accept-Encoding
+// header. Configure it before serving any requests.
+type Compressor struct {
+	MinSize  int      // Responses smaller than this are not compressed, unless flushed
+	Denylist []string // Content type prefixes that are not compressed
+
+	encodings []string // In order of preference
+	encoders  map[string]EncoderFunc
+	pools     map[string]*sync.Pool
+}
+
+// Constructs a new Compressor supporting gzip and deflate, compressing
+// responses of at least 1KB
+func NewCompressor() *Compressor {
+	c := &Compressor{
+		MinSize:  1024,
+		Denylist: DefaultCompressionDenylist,
+	}
+	c.Register("deflate", func(w io.Writer) io.WriteCloser {
+		zw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return zw
+	})
+	c.Register("gzip", func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	})
+
+	return c
+}
+
+// Registers an encoding, such as zstd or br, preferring it over the encodings
+// registered before it. Writers created by the encoder are flushed when the
+// response is flushed if they have a Flush() error method, and are reused if
+// they have a Reset(io.Writer) method.
+func (c *Compressor) Register(encoding string, encoder EncoderFunc) *Compressor {
+	encoding = strings.ToLower(encoding)
+	if c.encoders == nil {
+		c.encoders = make(map[string]EncoderFunc)
+		c.pools = make(map[string]*sync.Pool)
+	}
+	if _, ok := c.encoders[encoding]; !ok {
+		c.encodings = append([]string{encoding}, c.encodings...)
+	}
+	c.encoders[encoding] = encoder
+	c.pools[encoding] = &sync.Pool{}
+
+	return c
+}
+
+// Sets whether responses of the route, and of the routes under it unless they
+// set their own, are compressed. Routes compress by default when
+// Config.Compression is set, routes opting in otherwise use NewCompressor.
+func (r *Route) Compress(enabled bool) *Route {
+	r.compress = &enabled
+
+	return r
+}
+
+var defaultCompressor = NewCompressor()
+
+// Returns the compressor for the route, nil if responses are not compressed
+func (r *Route) effectiveCompressor() *Compressor {
+	c := r.yam.Config.Compression
+	for route := r; route != nil; route = route.parent {
+		if route.compress != nil {
+			if !*route.compress {
+				return nil
+			}
+			if c == nil {
+				c = defaultCompressor
+			}
+			break
+		}
+	}
+
+	return c
+}
+
+// Middleware compressing responses. Vary: Accept-Encoding is always set since
+// the response depends on it. Strong ETags set by the handler are weakened
+// when the response is compressed, ETags generated by YAM get the encoding
+// added instead.
+func (c *Compressor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := c.negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, c: c, encoding: encoding}
+		defer cw.close()
+
+		next.ServeHTTP(cw.wrap(), withValue(r, compressKey, cw))
+	})
+}
+
+// Picks the registered encoding best matching the Accept-Encoding header,
+// empty if the response should not be compressed
+func (c *Compressor) negotiate(header string) string {
+	best, bestQ := "", 0.0
+	for _, encoding := range c.encodings {
+		q, specificity := 0.0, -1
+		for _, part := range strings.Split(header, ",") {
+			value, quality := parseAccept(part)
+			s := -1
+			switch value {
+			case encoding:
+				s = 1
+			case "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = quality, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// Reports whether the content type is not to be compressed
+func (c *Compressor) denied(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range compressibleImages {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	for _, prefix := range c.Denylist {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Buffers the start of a response until it is known whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	c        *Compressor
+	encoding string
+
+	status      int
+	buf         []byte
+	decided     bool
+	compressing bool
+	zw          io.WriteCloser
+
+	// The ETag was generated by YAM from the uncompressed body, so the
+	// encoding can be added to it rather than weakening it
+	generatedETag bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.c.MinSize {
+			if err := w.decide(false); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if w.compressing {
+		return w.zw.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Decides whether to compress, writes the header and any buffered body. When
+// final the whole body is buffered, so small responses are not compressed,
+// when flushing the body is assumed to be a stream worth compressing.
+func (w *compressWriter) decide(final bool) error {
+	w.decided = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 && h.Get("X-Content-Type-Options") != "nosniff" {
+		// net/http would sniff the compressed body
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	w.compressing = w.status >= 200 &&
+		w.status != http.StatusNoContent &&
+		w.status != http.StatusNotModified &&
+		w.status != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" &&
+		h.Get("Content-Range") == "" &&
+		!strings.Contains(h.Get("Cache-Control"), "no-transform") &&
+		!w.c.denied(h.Get("Content-Type")) &&
+		!(final && len(w.buf) < w.c.MinSize)
+
+	if w.compressing {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			if w.generatedETag {
+				// Still strong, as compressing is deterministic, and
+				// understood by the ETag preconditions
+				h.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+w.encoding+`"`)
+			} else {
+				// The compressed bytes differ so the ETag is no longer strong
+				h.Set("ETag", "W/"+etag)
+			}
+		}
+		w.zw = w.encoder()
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.compressing {
+		_, err := w.zw.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// Returns a writer for the encoding, reused from the pool when possible
+func (w *compressWriter) encoder() io.WriteCloser {
+	if zw, ok := w.c.pools[w.encoding].Get().(io.WriteCloser); ok {
+		zw.(interface{ Reset(io.Writer) }).Reset(w.ResponseWriter)
+		return zw
+	}
+
+	return w.c.encoders[w.encoding](w.ResponseWriter)
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if f, ok := w.zw.(interface{ Flush() error }); ok && w.compressing {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// Returns the underlying writer for http.ResponseController
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Finishes the response once the handler has returned
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			// Nothing was written, leave it to net/http
+			return
+		}
+		w.decide(true)
+	}
+	if w.compressing {
+		w.zw.Close()
+		if _, ok := w.zw.(interface{ Reset(io.Writer) }); ok {
+			w.c.pools[w.encoding].Put(w.zw)
+		}
+	}
+}
+
+type unwrapper interface {
+	http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+// Returns the writer implementing http.Flusher and http.Hijacker when the
+// underlying writer does
+func (w *compressWriter) wrap() http.ResponseWriter {
+	_, isFlusher := w.ResponseWriter.(http.Flusher)
+	_, isHijacker := w.ResponseWriter.(http.Hijacker)
+
+	switch {
+	case isFlusher && isHijacker:
+		return w
+	case isFlusher:
+		return struct {
+			unwrapper
+			http.Flusher
+		}{w, w}
+	case isHijacker:
+		return struct {
+			unwrapper
+			http.Hijacker
+		}{w, w}
+	}
+
+	return struct{ unwrapper }{w}
+}
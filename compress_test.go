@@ -0,0 +1,177 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var large = strings.Repeat("Hello World! ", 200)
+
+func TestCompress(t *testing.T) {
+	text := func(body, contentType string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(body))
+		})
+	}
+
+	mux := New()
+	mux.Config.Compression = NewCompressor()
+	mux.Route("/large").Get(text(large, ""))
+	mux.Route("/small").Get(text("Hello World!", ""))
+	mux.Route("/image").Get(text(large, "image/png"))
+	mux.Route("/svg").Get(text(large, "image/svg+xml"))
+	mux.Route("/raw").Compress(false).Get(text(large, ""))
+
+	var tests = []struct {
+		path           string
+		acceptEncoding string
+		encoding       string
+	}{
+		{"/large", "gzip, deflate", "gzip"},
+		{"/large", "deflate", "deflate"},
+		{"/large", "gzip;q=0.5, deflate", "deflate"},
+		{"/large", "*", "gzip"},
+		{"/large", "br", ""},
+		{"/large", "", ""},
+		{"/large", "gzip;q=0", ""},
+		{"/small", "gzip", ""},
+		{"/image", "gzip", ""},
+		{"/svg", "gzip", "gzip"},
+		{"/raw", "gzip", ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Header().Get("Content-Encoding") != test.encoding {
+			t.Errorf("%s %q: Content-Encoding was %q, should be %q", test.path, test.acceptEncoding, res.Header().Get("Content-Encoding"), test.encoding)
+			continue
+		}
+		if test.path != "/raw" && res.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: Vary was %q, should be %q", test.path, res.Header().Get("Vary"), "Accept-Encoding")
+		}
+
+		var body io.Reader = res.Body
+		switch test.encoding {
+		case "gzip":
+			body, _ = gzip.NewReader(res.Body)
+		case "deflate":
+			body = flate.NewReader(res.Body)
+		}
+		b, _ := ioutil.ReadAll(body)
+		if test.encoding != "" {
+			if string(b) != large {
+				t.Errorf("%s: Decompressed body did not match", test.path)
+			}
+			if res.Header().Get("ETag") != `W/"v1"` {
+				t.Errorf("%s: ETag was %q, should be weak", test.path, res.Header().Get("ETag"))
+			}
+			if !strings.HasPrefix(res.Header().Get("Content-Type"), "text/plain") && test.path != "/svg" {
+				t.Errorf("%s: Content-Type was %q, should be sniffed from the uncompressed body", test.path, res.Header().Get("Content-Type"))
+			}
+		}
+	}
+}
+
+func TestCompressRegister(t *testing.T) {
+	var used bool
+	c := NewCompressor().Register("x-test", func(w io.Writer) io.WriteCloser {
+		used = true
+		return gzip.NewWriter(w)
+	})
+
+	mux := New()
+	mux.Config.Compression = c
+	mux.Route("/").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(large))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, x-test")
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	if res.Header().Get("Content-Encoding") != "x-test" || !used {
+		t.Errorf("Content-Encoding was %q, registered encodings should be preferred", res.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	mux := New()
+	mux.Route("/events").Compress(true).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: one\n\n"))
+		w.(http.Flusher).Flush()
+	}))
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	if !res.Flushed {
+		t.Error("Response should have been flushed")
+	}
+	if res.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Content-Encoding was %q, flushed streams should be compressed", res.Header().Get("Content-Encoding"))
+	}
+	zr, _ := gzip.NewReader(res.Body)
+	b, _ := ioutil.ReadAll(zr)
+	if string(b) != "data: one\n\n" {
+		t.Errorf("Body was %q", b)
+	}
+}
+
+func TestCompressHead(t *testing.T) {
+	mux := New()
+	mux.Config.Compression = NewCompressor()
+	mux.Route("/").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(large))
+	}))
+
+	headers := map[string]http.Header{}
+	lengths := map[string]int{}
+	for _, method := range []string{"GET", "HEAD"} {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		headers[method] = res.Header()
+		lengths[method] = res.Body.Len()
+	}
+
+	if headers["HEAD"].Get("Content-Encoding") != "gzip" {
+		t.Errorf("HEAD Content-Encoding was %q, should be %q", headers["HEAD"].Get("Content-Encoding"), "gzip")
+	}
+	if lengths["HEAD"] != 0 {
+		t.Errorf("HEAD body was %d bytes, should be empty", lengths["HEAD"])
+	}
+	if headers["HEAD"].Get("Content-Length") == "" {
+		t.Error("HEAD should have the Content-Length of the compressed body")
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(large))
+	zw.Close()
+	if lengths["GET"] != buf.Len() {
+		t.Errorf("GET body was %d bytes, should be %d", lengths["GET"], buf.Len())
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits the handlers of the route, and of the routes under it, to serving n
+// requests at a time. Up to queue further requests wait for at most wait for
+// their turn, requests that can not be queued or wait too long are replied
+// to with a 503 Service Unavailable through the error handler.
+//
+//	mux.Route("/reports/:id").MaxConcurrent(4, 16, time.Second).Get(report)
+func (r *Route) MaxConcurrent(n, queue int, wait time.Duration) *Route {
+	r.concurrency = NewConcurrencyLimiter(n, queue, wait)
+
+	return r.Use(r.concurrency.Middleware)
+}
+
+// Sheds load on the handlers of the route, and of the routes under it, with
+// an adaptive limiter
+//
+//	mux.Route("/search").LoadShed(yam.NewAdaptiveLimiter(10, 200, 100*time.Millisecond))
+func (r *Route) LoadShed(l *AdaptiveLimiter) *Route {
+	r.shedder = l
+
+	return r.Use(l.Middleware)
+}
+
+// Load on the limiters of a route
+type ConcurrencyStats struct {
+	Limit    int // Maximum concurrent requests, 0 when there is no limit
+	InFlight int // Requests being served
+	Queued   int // Requests waiting to be served
+}
+
+// Returns the load on the concurrency limit of the route, set on the route
+// or inherited from the routes above it. When the route only sheds load the
+// stats are those of its AdaptiveLimiter.
+func (r *Route) Concurrency() ConcurrencyStats {
+	for route := r; route != nil; route = route.parent {
+		if l := route.concurrency; l != nil {
+			return ConcurrencyStats{
+				Limit:    l.Limit(),
+				InFlight: l.InFlight(),
+				Queued:   l.Queued(),
+			}
+		}
+		if l := route.shedder; l != nil {
+			return ConcurrencyStats{
+				Limit:    l.Limit(),
+				InFlight: l.InFlight(),
+			}
+		}
+	}
+
+	return ConcurrencyStats{}
+}
+
+// Limits the number of requests served at a time with a bounded wait queue
+type ConcurrencyLimiter struct {
+	slots  chan struct{}
+	queue  chan struct{}
+	wait   time.Duration
+	queued int64
+}
+
+// Constructs a new ConcurrencyLimiter serving n requests at a time, with up
+// to queue requests waiting for at most wait
+func NewConcurrencyLimiter(n, queue int, wait time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		slots: make(chan struct{}, n),
+		queue: make(chan struct{}, queue),
+		wait:  wait,
+	}
+}
+
+// Returns the maximum number of requests served at a time
+func (l *ConcurrencyLimiter) Limit() int {
+	return cap(l.slots)
+}
+
+// Returns the number of requests being served
+func (l *ConcurrencyLimiter) InFlight() int {
+	return len(l.slots)
+}
+
+// Returns the number of requests waiting to be served
+func (l *ConcurrencyLimiter) Queued() int {
+	return int(atomic.LoadInt64(&l.queued))
+}
+
+// Middleware limiting the number of requests served at a time
+func (l *ConcurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(r) {
+			w.Header().Set("Retry-After", "1")
+			Error(w, r, NewProblem(http.StatusServiceUnavailable, "the server is too busy"))
+			return
+		}
+		defer func() { <-l.slots }()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Takes a slot, waiting in the queue if there is room
+func (l *ConcurrencyLimiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return false
+	}
+	atomic.AddInt64(&l.queued, 1)
+	defer func() {
+		atomic.AddInt64(&l.queued, -1)
+		<-l.queue
+	}()
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// An AIMD (additive increase, multiplicative decrease) concurrency limiter
+// for load shedding. The limit grows while requests complete within the
+// target latency and is cut back when they are slower or fail, requests over
+// the limit are rejected straight away with a 503 Service Unavailable.
+type AdaptiveLimiter struct {
+	Min     int           // Lowest the limit can fall to, at least 1
+	Max     int           // Highest the limit can grow to, at least Min
+	Latency time.Duration // Target latency
+	Backoff float64       // Factor the limit is multiplied by when the target is missed
+
+	mu          sync.Mutex
+	limit       float64
+	initialised bool // The limit has been set to Max
+	inFlight    int
+}
+
+// Constructs a new AdaptiveLimiter starting at the max limit, backing off by
+// 10% when requests take longer than latency. A min below 1 is raised to 1
+// since a limit of 0 would refuse every request and never recover.
+func NewAdaptiveLimiter(min, max int, latency time.Duration) *AdaptiveLimiter {
+	if min < 1 {
+		min = 1
+	}
+	l := &AdaptiveLimiter{
+		Min:     min,
+		Max:     max,
+		Latency: latency,
+		Backoff: 0.9,
+	}
+	_, upper := l.bounds()
+	l.limit, l.initialised = float64(upper), true
+
+	return l
+}
+
+// Returns the lowest and highest the limit can be
+func (l *AdaptiveLimiter) bounds() (int, int) {
+	min := l.Min
+	if min < 1 {
+		min = 1
+	}
+	max := l.Max
+	if max < min {
+		max = min
+	}
+
+	return min, max
+}
+
+// Returns the current limit
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
+
+// Returns the number of requests being served
+func (l *AdaptiveLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.inFlight
+}
+
+// Middleware shedding requests over the limit
+func (l *AdaptiveLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.mu.Lock()
+		if !l.initialised {
+			_, max := l.bounds()
+			l.limit, l.initialised = float64(max), true
+		}
+		if l.inFlight >= int(l.limit) {
+			l.mu.Unlock()
+			w.Header().Set("Retry-After", "1")
+			Error(w, r, NewProblem(http.StatusServiceUnavailable, "the server is too busy"))
+			return
+		}
+		l.inFlight++
+		l.mu.Unlock()
+
+		start := time.Now()
+		ww := WrapWriter(w)
+		defer func() {
+			l.done(time.Since(start), ww.Status() >= 500)
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// Adjusts the limit for a completed request
+func (l *AdaptiveLimiter) done(latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	min, max := l.bounds()
+	if failed || latency > l.Latency {
+		l.limit = math.Max(float64(min), l.limit*l.Backoff)
+	} else {
+		l.limit = math.Min(float64(max), l.limit+1/l.limit)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaxConcurrent(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+
+	mux := New()
+	route := mux.Route("/reports/:id").MaxConcurrent(1, 1, time.Second)
+	route.Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, httptest.NewRequest("GET", "/reports/1", nil))
+			codes <- res.Code
+		}()
+	}
+
+	// One request is served and one is queued
+	<-started
+	for route.Concurrency().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	stats := route.Concurrency()
+	if stats != (ConcurrencyStats{Limit: 1, InFlight: 1, Queued: 1}) {
+		t.Errorf("Stats were %+v", stats)
+	}
+
+	// The queue is full so further requests are rejected
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/reports/2", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status was %v, should be %v", res.Code, http.StatusServiceUnavailable)
+	}
+
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Status was %v, should be %v", code, http.StatusOK)
+		}
+	}
+}
+
+func TestMaxConcurrentQueueTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+
+	mux := New()
+	mux.Route("/").MaxConcurrent(1, 1, 10*time.Millisecond).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	go mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status was %v, should be %v", res.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	delay := time.Duration(0)
+	l := NewAdaptiveLimiter(1, 10, 5*time.Millisecond)
+
+	mux := New()
+	route := mux.Route("/").LoadShed(l).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+	}))
+
+	delay = 10 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	if l.Limit() != 7 {
+		t.Errorf("Limit was %v, should have backed off to %v", l.Limit(), 7)
+	}
+
+	delay = 0
+	for i := 0; i < 10; i++ {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	if l.Limit() != 8 {
+		t.Errorf("Limit was %v, should have grown to %v", l.Limit(), 8)
+	}
+
+	// Requests over the limit are shed
+	l.mu.Lock()
+	l.inFlight = l.Max
+	l.mu.Unlock()
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status was %v, should be %v", res.Code, http.StatusServiceUnavailable)
+	}
+	if route.Concurrency().InFlight != 10 {
+		t.Errorf("InFlight was %v, should be %v", route.Concurrency().InFlight, 10)
+	}
+}
+
+func TestAdaptiveLimiterMin(t *testing.T) {
+	var tests = []*AdaptiveLimiter{
+		NewAdaptiveLimiter(0, 2, time.Nanosecond),
+		{Latency: time.Nanosecond, Backoff: 0.5},
+	}
+
+	for i, l := range tests {
+		mux := New()
+		mux.Route("/").LoadShed(l).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Millisecond)
+		}))
+
+		// Every request misses the target, the limit stays at 1 so
+		// requests made one at a time are still served
+		for j := 0; j < 20; j++ {
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+			if res.Code != http.StatusOK {
+				t.Fatalf("%d: Status of request %d was %v, should be %v", i, j, res.Code, http.StatusOK)
+			}
+		}
+		if l.Limit() != 1 {
+			t.Errorf("%d: Limit was %v, should be %v", i, l.Limit(), 1)
+		}
+	}
+}
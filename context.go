@@ -0,0 +1,72 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Context key type for values YAM places on the request context
+type contextKey int
+
+const (
+	yamKey       contextKey = iota // The *Yam serving the request
+	routeKey                       // The *RouteInfo of the request
+	requestIDKey                   // The ID of the request
+	nonceKey                       // The Content-Security-Policy nonce of the request
+	clientIPKey                    // The IP address of the client
+	compressKey                    // The *compressWriter compressing the response
+)
+
+// Describes the route that matched a request
+type RouteInfo struct {
+	Route   *Route                 // The matched route
+	Pattern string                 // Full pattern of the route, for example "/users/:id"
+	Params  url.Values             // Values of the pattern segments, keyed as in the URL query, for example ":id"
+	Meta    map[string]interface{} // Metadata of the route, including metadata inherited from parent routes
+
+	clientIP  string // Resolved IP address of the client, read by ClientIP
+	requestID string // ID of the request, read by RequestID
+}
+
+// Returns information about the route serving the request, or nil if the
+// request has not been matched to a route handler, for example when YAM
+// replies with a 404 or 405
+func CurrentRoute(r *http.Request) *RouteInfo {
+	info, ok := r.Context().Value(routeKey).(*RouteInfo)
+	if !ok || info.Route == nil {
+		return nil
+	}
+
+	return info
+}
+
+// Returns a shallow copy of the request carrying an empty RouteInfo, which YAM
+// fills in when it serves the request. Middleware wrapping the mux can use
+// this to read CurrentRoute after ServeHTTP returns:
+//
+//	func Log(next http.Handler) http.Handler {
+//		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//			r = yam.WithRouteInfo(r)
+//			next.ServeHTTP(w, r)
+//			if info := yam.CurrentRoute(r); info != nil {
+//				log.Println(info.Pattern)
+//			}
+//		})
+//	}
+func WithRouteInfo(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(routeKey).(*RouteInfo); ok {
+		return r
+	}
+
+	return withValue(r, routeKey, &RouteInfo{})
+}
+
+// Returns a shallow copy of the request with the value set on its context
+func withValue(r *http.Request, key, value interface{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), key, value))
+}
@@ -0,0 +1,77 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCurrentRoute(t *testing.T) {
+	var info *RouteInfo
+
+	mux := New()
+	users := mux.Route("/users").Meta("scope", "users").Meta("team", "accounts")
+	route := users.Route("/:id").Meta("scope", "admin")
+	route.Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info = CurrentRoute(r)
+	}))
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42", nil))
+
+	if info == nil {
+		t.Fatal("CurrentRoute should not be nil")
+	}
+	if info.Route != route {
+		t.Errorf("Route was %v, should be %v", info.Route, route)
+	}
+	if info.Pattern != "/users/:id" {
+		t.Errorf("Pattern was %v, should be %v", info.Pattern, "/users/:id")
+	}
+	if info.Params.Get(":id") != "42" {
+		t.Errorf("Param :id was %v, should be %v", info.Params.Get(":id"), "42")
+	}
+	if info.Meta["scope"] != "admin" {
+		t.Errorf("Meta scope was %v, should be %v", info.Meta["scope"], "admin")
+	}
+	if info.Meta["team"] != "accounts" {
+		t.Errorf("Meta team was %v, should be %v", info.Meta["team"], "accounts")
+	}
+}
+
+func TestCurrentRouteOuterMiddleware(t *testing.T) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mux := New()
+	mux.Route("/users/:id").Get(fn)
+
+	var tests = []struct {
+		method  string
+		path    string
+		pattern string
+	}{
+		{"GET", "/users/42", "/users/:id"},
+		{"POST", "/users/42", ""},
+		{"GET", "/nope", ""},
+	}
+
+	for _, test := range tests {
+		pattern := ""
+		outer := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = WithRouteInfo(r)
+			mux.ServeHTTP(w, r)
+			if info := CurrentRoute(r); info != nil {
+				pattern = info.Pattern
+			}
+		})
+
+		outer.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(test.method, test.path, nil))
+
+		if pattern != test.pattern {
+			t.Errorf("%s %s: Pattern was %q, should be %q", test.method, test.path, pattern, test.pattern)
+		}
+	}
+}
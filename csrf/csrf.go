@@ -0,0 +1,266 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+/*
+Package csrf provides cross-site request forgery protection for YAM routes
+authenticated with cookies, using signed double-submit tokens and Origin and
+Sec-Fetch-Site checks.
+
+	protect := csrf.New(key)
+	mux := yam.New()
+	mux.Use(protect.Middleware)
+	mux.Route("/account").Get(account).Post(updateAccount)
+	mux.Route("/api").Meta(csrf.Exempt, true)
+
+The token is set in a cookie and must be sent back with unsafe requests in the
+X-CSRF-Token header or the csrf_token form field. Templates can include it
+with TemplateField:
+
+	<form method="post">{{ .CSRF }}</form>
+
+	tmpl.Execute(w, map[string]interface{}{"CSRF": csrf.TemplateField(r)})
+
+Subtrees authenticated with tokens rather than cookies, such as an API, are
+not vulnerable and can be exempted with the Exempt metadata key.
+*/
+package csrf
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thisissoon/yam"
+)
+
+// Route metadata key, routes with this set to true are not protected
+const Exempt = "csrf.exempt"
+
+// Length of tokens in bytes
+const tokenLength = 32
+
+var b64 = base64.RawURLEncoding
+
+// CSRF protection settings
+type CSRF struct {
+	Key            []byte   // Secret the token cookie is signed with
+	CookieName     string   // Name of the token cookie, _csrf by default
+	HeaderName     string   // Header unsafe requests send the token in, X-CSRF-Token by default
+	FieldName      string   // Form field unsafe requests send the token in, csrf_token by default
+	TrustedOrigins []string // Hosts, other than the host of the request, unsafe requests may come from
+
+	// Attributes of the token cookie, it is always HttpOnly
+	Path     string
+	Domain   string
+	MaxAge   time.Duration
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// Constructs a new CSRF signing tokens with key, which should be at least 32
+// random bytes and kept secret
+func New(key []byte) *CSRF {
+	return &CSRF{
+		Key:        key,
+		CookieName: "_csrf",
+		HeaderName: "X-CSRF-Token",
+		FieldName:  "csrf_token",
+		Path:       "/",
+		MaxAge:     12 * time.Hour,
+		Secure:     true,
+		SameSite:   http.SameSiteLaxMode,
+	}
+}
+
+type contextKey struct{}
+
+// Token of a request stored on its context
+type requestToken struct {
+	token []byte
+	field string
+}
+
+// Returns the CSRF token of the request to send back with unsafe requests,
+// empty if the request was not served by the middleware. The token is masked
+// differently on every call so it does not leak through compression.
+func Token(r *http.Request) string {
+	t, ok := r.Context().Value(contextKey{}).(*requestToken)
+	if !ok {
+		return ""
+	}
+
+	return mask(t.token)
+}
+
+// Returns a hidden form input holding the CSRF token of the request
+func TemplateField(r *http.Request) template.HTML {
+	name := "csrf_token"
+	if t, ok := r.Context().Value(contextKey{}).(*requestToken); ok {
+		name = t.field
+	}
+
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(name) + `" value="` + template.HTMLEscapeString(Token(r)) + `">`)
+}
+
+// Middleware protecting unsafe requests. Requests using GET, HEAD, OPTIONS
+// or TRACE are allowed and are given a token cookie if they do not have one.
+// Other requests are replied to with a 403 Forbidden through the error
+// handler of the mux when they come from another site according to their
+// Sec-Fetch-Site or Origin headers, or do not send the token of their
+// cookie.
+func (c *CSRF) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := yam.CurrentRoute(r); info != nil {
+			if exempt, _ := info.Meta[Exempt].(bool); exempt {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Add("Vary", "Cookie")
+		token := c.cookieToken(r)
+		if token == nil {
+			token = make([]byte, tokenLength)
+			rand.Read(token)
+			http.SetCookie(w, &http.Cookie{
+				Name:     c.CookieName,
+				Value:    c.sign(token),
+				Path:     c.Path,
+				Domain:   c.Domain,
+				MaxAge:   int(c.MaxAge.Seconds()),
+				Secure:   c.Secure,
+				HttpOnly: true,
+				SameSite: c.SameSite,
+			})
+		}
+		r = r.WithContext(context.WithValue(r.Context(), contextKey{}, &requestToken{token, c.FieldName}))
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !c.sameOrigin(r) {
+			yam.Error(w, r, yam.NewProblem(http.StatusForbidden, "the request comes from another site"))
+			return
+		}
+
+		sent := r.Header.Get(c.HeaderName)
+		if sent == "" {
+			sent = r.PostFormValue(c.FieldName)
+		}
+		if submitted := unmask(sent); submitted == nil || subtle.ConstantTimeCompare(submitted, token) != 1 {
+			yam.Error(w, r, yam.NewProblem(http.StatusForbidden, "the CSRF token is missing or not valid"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Reports whether an unsafe request comes from the same origin, or a trusted
+// one. Browsers send Sec-Fetch-Site, and Origin with unsafe requests, older
+// browsers sending neither rely on the token alone.
+func (c *CSRF) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin != "" && origin != "null" {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		if c.trusted(u.Host) {
+			return true
+		}
+		if !strings.EqualFold(u.Host, r.Host) {
+			return false
+		}
+	}
+
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+		return true
+	}
+
+	return false
+}
+
+// Reports whether requests from a host are trusted
+func (c *CSRF) trusted(host string) bool {
+	for _, t := range c.TrustedOrigins {
+		if strings.EqualFold(t, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the token of the cookie of the request, nil if there is none or its
+// signature is not valid
+func (c *CSRF) cookieToken(r *http.Request) []byte {
+	cookie, err := r.Cookie(c.CookieName)
+	if err != nil {
+		return nil
+	}
+	value, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return nil
+	}
+	token, err := b64.DecodeString(value)
+	if err != nil || len(token) != tokenLength {
+		return nil
+	}
+	sig, err := b64.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, c.mac(token)) {
+		return nil
+	}
+
+	return token
+}
+
+// Returns the cookie value for a token
+func (c *CSRF) sign(token []byte) string {
+	return b64.EncodeToString(token) + "." + b64.EncodeToString(c.mac(token))
+}
+
+func (c *CSRF) mac(token []byte) []byte {
+	h := hmac.New(sha256.New, c.Key)
+	h.Write(token)
+
+	return h.Sum(nil)
+}
+
+// Masks a token with a random one time pad, the pad is prepended
+func mask(token []byte) string {
+	b := make([]byte, 2*len(token))
+	rand.Read(b[:len(token)])
+	for i := range token {
+		b[len(token)+i] = b[i] ^ token[i]
+	}
+
+	return b64.EncodeToString(b)
+}
+
+// Returns the token of a masked token, nil if it is not valid
+func unmask(s string) []byte {
+	b, err := b64.DecodeString(s)
+	if err != nil || len(b) != 2*tokenLength {
+		return nil
+	}
+	token := make([]byte, tokenLength)
+	for i := range token {
+		token[i] = b[i] ^ b[tokenLength+i]
+	}
+
+	return token
+}
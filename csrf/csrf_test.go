@@ -0,0 +1,192 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thisissoon/yam"
+)
+
+func newMux() (*yam.Yam, *string) {
+	token := new(string)
+
+	mux := yam.New()
+	mux.Use(New([]byte("secret")).Middleware)
+	mux.Route("/form").
+		Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*token = Token(r)
+			w.Write([]byte(TemplateField(r)))
+		})).
+		Post(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+	mux.Route("/api").Meta(Exempt, true).Route("/things").Post(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	return mux, token
+}
+
+func TestMiddleware(t *testing.T) {
+	mux, token := newMux()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/form", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "_csrf" || !cookies[0].HttpOnly {
+		t.Fatalf("Cookies were %v, should be a HttpOnly _csrf cookie", cookies)
+	}
+	cookie := cookies[0]
+	if *token == "" {
+		t.Fatal("Token was empty")
+	}
+	if body := w.Body.String(); !strings.HasPrefix(body, `<input type="hidden" name="csrf_token" value="`) || strings.Contains(body, *token) {
+		t.Errorf("Template field was %q, should hold a differently masked token", body)
+	}
+
+	// A request with the cookie keeps the token and is not sent a new cookie
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/form", nil)
+	req.AddCookie(cookie)
+	mux.ServeHTTP(w, req)
+	if c := w.Result().Cookies(); len(c) != 0 {
+		t.Errorf("Cookies were %v, should be none", c)
+	}
+
+	forged := mask(make([]byte, tokenLength))
+	tokenForged := &http.Cookie{Name: "_csrf", Value: (&CSRF{Key: []byte("other")}).sign(make([]byte, tokenLength))}
+
+	tests := []struct {
+		name   string
+		path   string
+		cookie *http.Cookie
+		header map[string]string
+		form   url.Values
+		status int
+	}{
+		{"header token", "/form", cookie, map[string]string{"X-CSRF-Token": *token}, nil, http.StatusOK},
+		{"form token", "/form", cookie, nil, url.Values{"csrf_token": {*token}}, http.StatusOK},
+		{"no token", "/form", cookie, nil, nil, http.StatusForbidden},
+		{"no cookie", "/form", nil, map[string]string{"X-CSRF-Token": *token}, nil, http.StatusForbidden},
+		{"wrong token", "/form", cookie, map[string]string{"X-CSRF-Token": forged}, nil, http.StatusForbidden},
+		{"unsigned cookie", "/form", tokenForged, map[string]string{"X-CSRF-Token": forged}, nil, http.StatusForbidden},
+		{"same origin", "/form", cookie, map[string]string{"X-CSRF-Token": *token, "Origin": "http://example.com", "Sec-Fetch-Site": "same-origin"}, nil, http.StatusOK},
+		{"cross origin", "/form", cookie, map[string]string{"X-CSRF-Token": *token, "Origin": "https://evil.com"}, nil, http.StatusForbidden},
+		{"cross site", "/form", cookie, map[string]string{"X-CSRF-Token": *token, "Sec-Fetch-Site": "cross-site"}, nil, http.StatusForbidden},
+		{"same site", "/form", cookie, map[string]string{"X-CSRF-Token": *token, "Sec-Fetch-Site": "same-site"}, nil, http.StatusForbidden},
+		{"exempt", "/api/things", nil, map[string]string{"Origin": "https://evil.com"}, nil, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		var req *http.Request
+		if test.form != nil {
+			req = httptest.NewRequest("POST", test.path, strings.NewReader(test.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest("POST", test.path, nil)
+		}
+		for k, v := range test.header {
+			req.Header.Set(k, v)
+		}
+		if test.cookie != nil {
+			req.AddCookie(test.cookie)
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s: Status was %d, should be %d", test.name, w.Code, test.status)
+		}
+	}
+}
+
+func TestMiddlewareCache(t *testing.T) {
+	mux := yam.New()
+	mux.Use(New([]byte("secret")).Middleware)
+	mux.Route("/form").Cache(time.Minute).
+		Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(Token(r)))
+		})).
+		Post(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+
+	// Each new visitor gets a page holding the token of their own cookie,
+	// the pages are fetched before posting as posting purges the cache
+	pages := make([]*httptest.ResponseRecorder, 2)
+	for i := range pages {
+		pages[i] = httptest.NewRecorder()
+		mux.ServeHTTP(pages[i], httptest.NewRequest("GET", "/form", nil))
+	}
+	for i, page := range pages {
+		cookies := page.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("Visitor %d: Cookies were %v, should be a _csrf cookie", i, cookies)
+		}
+
+		req := httptest.NewRequest("POST", "/form", nil)
+		req.Header.Set("X-CSRF-Token", page.Body.String())
+		req.AddCookie(cookies[0])
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("Visitor %d: Status was %d, should be %d", i, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestTrustedOrigins(t *testing.T) {
+	c := New([]byte("secret"))
+	c.TrustedOrigins = []string{"app.example.com"}
+
+	tests := []struct {
+		origin string
+		site   string
+		same   bool
+	}{
+		{"", "", true},
+		{"http://example.com", "", true},
+		{"https://app.example.com", "same-site", true},
+		{"https://other.example.com", "same-site", false},
+		{"null", "none", true},
+		{"null", "cross-site", false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/", nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		if test.site != "" {
+			req.Header.Set("Sec-Fetch-Site", test.site)
+		}
+
+		if same := c.sameOrigin(req); same != test.same {
+			t.Errorf("Origin %q, Sec-Fetch-Site %q: same origin was %v, should be %v", test.origin, test.site, same, test.same)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	token := []byte(strings.Repeat("t", tokenLength))
+
+	a, b := mask(token), mask(token)
+	if a == b {
+		t.Error("Masked tokens should differ")
+	}
+	if string(unmask(a)) != string(token) || string(unmask(b)) != string(token) {
+		t.Error("Masked tokens should unmask to the token")
+	}
+	if unmask("nope") != nil {
+		t.Error("Invalid token should not unmask")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Content codings request bodies can be decompressed from
+var decompressEncodings = "gzip, deflate"
+
+// Decompresses request bodies sent with a gzip or deflate Content-Encoding for
+// the handlers of the route, and of the routes under it unless they set their
+// own. The decompressed body is limited to n bytes to guard against zip
+// bombs, reading past it returns a *http.MaxBytesError which the error
+// handler replies to with a 413. A limit of zero or less disables
+// decompression.
+//
+//	mux.Route("/ingest").Decompress(10 << 20).Post(ingest)
+//
+// Requests with any other encoding are replied to with a 415 Unsupported Media
+// Type. The Content-Encoding and Content-Length headers are removed before the
+// handler runs.
+func (r *Route) Decompress(n int64) *Route {
+	r.decompress = &n
+
+	return r
+}
+
+// Returns the decompressed body limit for the route, set on the route or
+// inherited from the routes above it, zero if bodies are not decompressed
+func (r *Route) effectiveDecompress() int64 {
+	for route := r; route != nil; route = route.parent {
+		if route.decompress != nil {
+			return *route.decompress
+		}
+	}
+
+	return 0
+}
+
+// Replaces the body of requests with a Content-Encoding with a reader
+// decompressing it, limited to n bytes
+func decompressHandler(next http.Handler, n int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Content-Encoding")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Codings are listed in the order they were applied so are
+		// removed in reverse
+		encodings := strings.Split(header, ",")
+		for i := len(encodings) - 1; i >= 0; i-- {
+			switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+			case "gzip", "x-gzip", "deflate", "identity":
+			default:
+				w.Header().Set("Accept-Encoding", decompressEncodings)
+				Error(w, r, NewProblem(http.StatusUnsupportedMediaType, "the request body encoding "+strings.TrimSpace(encodings[i])+" is not supported"))
+				return
+			}
+		}
+
+		if r.Body != nil && r.Body != http.NoBody {
+			body := &decompressBody{body: r.Body}
+			var rd io.Reader = r.Body
+			for i := len(encodings) - 1; i >= 0; i-- {
+				var err error
+				switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+				case "gzip", "x-gzip":
+					rd, err = gzip.NewReader(rd)
+				case "deflate":
+					rd, err = newDeflateReader(rd)
+				}
+				if err != nil {
+					Error(w, r, NewProblem(http.StatusBadRequest, "the request body is not valid "+strings.TrimSpace(encodings[i])))
+					return
+				}
+				if c, ok := rd.(io.Closer); ok {
+					body.closers = append(body.closers, c)
+				}
+			}
+			body.Reader = rd
+			r.Body = http.MaxBytesReader(w, body, n)
+		}
+
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns a reader for a deflate body. The deflate coding is zlib wrapped but
+// some clients send raw deflate so both are accepted.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	b, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+
+	return flate.NewReader(br), nil
+}
+
+// A decompressed request body, read errors from corrupt data are replied to
+// with a 400 Bad Request
+type decompressBody struct {
+	io.Reader
+	body    io.Closer
+	closers []io.Closer
+}
+
+// Implements the io.Reader interface
+func (b *decompressBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	var maxBytes *http.MaxBytesError
+	if err != nil && err != io.EOF && !errors.As(err, &maxBytes) {
+		err = NewProblem(http.StatusBadRequest, "the request body could not be decompressed: "+err.Error())
+	}
+
+	return n, err
+}
+
+// Implements the io.Closer interface, closing the decompressors and the
+// original body
+func (b *decompressBody) Close() error {
+	for _, c := range b.closers {
+		c.Close()
+	}
+
+	return b.body.Close()
+}
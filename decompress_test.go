@@ -0,0 +1,136 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	echo := HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("X-Content-Encoding", r.Header.Get("Content-Encoding"))
+		w.Write(b)
+		return nil
+	})
+
+	mux := New()
+	mux.Route("/ingest").Decompress(1024).Post(echo)
+	mux.Route("/ingest/raw").Decompress(0).Post(echo)
+	mux.Route("/plain").Post(echo)
+
+	var zl, raw bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte("Hello zlib"))
+	zw.Close()
+	fw, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	fw.Write([]byte("Hello deflate"))
+	fw.Close()
+
+	corrupt := gzipped("Hello World")
+	corrupt[len(corrupt)-8]++
+
+	var tests = []struct {
+		path     string
+		encoding string
+		body     []byte
+		status   int
+		expected string
+	}{
+		{"/ingest", "gzip", gzipped("Hello World"), http.StatusOK, "Hello World"},
+		{"/ingest", "GZIP", gzipped("Hello World"), http.StatusOK, "Hello World"},
+		{"/ingest", "deflate", zl.Bytes(), http.StatusOK, "Hello zlib"},
+		{"/ingest", "deflate", raw.Bytes(), http.StatusOK, "Hello deflate"},
+		{"/ingest", "", []byte("Hello World"), http.StatusOK, "Hello World"},
+		{"/ingest", "identity", []byte("Hello World"), http.StatusOK, "Hello World"},
+		{"/ingest", "gzip, gzip", gzipped(string(gzipped("Hello World"))), http.StatusOK, "Hello World"},
+		{"/ingest", "br", []byte("Hello World"), http.StatusUnsupportedMediaType, ""},
+		{"/ingest", "gzip", []byte("Hello World"), http.StatusBadRequest, ""},
+		{"/ingest", "gzip", corrupt, http.StatusBadRequest, ""},
+		{"/ingest", "gzip", gzipped(strings.Repeat("a", 1025)), http.StatusRequestEntityTooLarge, ""},
+		{"/ingest/raw", "gzip", gzipped("Hello World"), http.StatusOK, string(gzipped("Hello World"))},
+		{"/plain", "gzip", gzipped("Hello World"), http.StatusOK, string(gzipped("Hello World"))},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", test.path, bytes.NewReader(test.body))
+		if test.encoding != "" {
+			req.Header.Set("Content-Encoding", test.encoding)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%s %q: Status was %d, should be %d", test.path, test.encoding, res.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		if res.Body.String() != test.expected {
+			t.Errorf("%s %q: Body was %q, should be %q", test.path, test.encoding, res.Body.String(), test.expected)
+		}
+		if test.path == "/ingest" && res.Header().Get("X-Content-Encoding") != "" {
+			t.Errorf("%s %q: Content-Encoding should be removed", test.path, test.encoding)
+		}
+	}
+}
+
+func TestDecompressUnsupported(t *testing.T) {
+	mux := New()
+	mux.Route("/").Decompress(1024).Post(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should not be called")
+	}))
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("Hello World"))
+	req.Header.Set("Content-Encoding", "compress")
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	if res.Header().Get("Accept-Encoding") != "gzip, deflate" {
+		t.Errorf("Accept-Encoding was %q, should list the supported encodings", res.Header().Get("Accept-Encoding"))
+	}
+}
+
+func TestDecompressMaxBody(t *testing.T) {
+	var n int
+	mux := New()
+	mux.Route("/").MaxBody(64).Decompress(1 << 20).Post(HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		b, err := ioutil.ReadAll(r.Body)
+		n = len(b)
+		return err
+	}))
+
+	// A small compressed body expanding well past the compressed limit
+	body := gzipped(strings.Repeat("a", 4096))
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	req.Header.Set("Content-Encoding", "gzip")
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK || n != 4096 {
+		t.Errorf("Status was %d reading %d bytes, MaxBody should limit the compressed body", res.Code, n)
+	}
+}
@@ -28,6 +28,27 @@ Yam has the following features:
 	- Support for all the standard HTTP verbs out of the box (OPTIONS, GET, HEAD, POST, PUT, PATCH, DELETE, TRACE)
 	- Sub Routing
 	- Configuration, allowing default handler functions overrides and flags for OPTIONS and TRACE
+	- RFC 7807 Problem Details error responses, negotiated with the Accept header
+	- Optional panic recovery with a pluggable reporter
+	- Matched route pattern and user defined metadata available on the request context
+	- Request ID generation and propagation
+	- Per route timeouts with subtree defaults
+	- Route middleware and in memory rate limiting
+	- Concurrency limits and adaptive load shedding
+	- Request body size limits
+	- Negotiated gzip and deflate response compression
+	- Transparent decompression of request bodies
+	- ETag generation and conditional requests
+	- In memory response caching with stale-while-revalidate
+	- Coalescing of identical concurrent GET requests
+	- Idempotency-Key support for POST and PATCH
+	- Static file and embed.FS serving with an SPA fallback
+	- Basic, bearer token and JWT authentication
+	- Declarative per route authorization with an RBAC implementation
+	- CSRF protection with signed double-submit tokens and Origin checks
+	- Security headers, including HSTS and CSP nonces, with per subtree policies
+	- Client IP resolution through trusted proxies and per route IP allow and deny lists
+	- HTTPS and canonical host redirects
 
 Method Based Routing
 
@@ -76,6 +97,88 @@ You can apply middlware globaly to all the routes or on a route by route basis.
 		http.ListenAndServe(":5000", GlobalMiddleware(mux))
 	}
 
+Middleware can also be added to the mux itself with Use. It then runs after the request has been
+matched to a route, so CurrentRoute is available, and also wraps YAM's own 404 and 405 responses:
+
+	mux := yam.New()
+	mux.Use(GlobalMiddleware)
+
+Middleware that needs to know the status or size of a response can use WrapWriter, which records them
+while still implementing exactly the optional interfaces (http.Flusher, http.Hijacker, io.ReaderFrom and
+http.Pusher) of the writer it wraps, so streaming and websockets keep working:
+
+	func Status(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := yam.WrapWriter(w)
+			next.ServeHTTP(ww, r)
+			log.Println(r.URL.Path, ww.Status(), ww.BytesWritten())
+		})
+	}
+
+Metrics
+
+The metrics package collects request counts, latencies, response sizes and in flight requests labelled
+by route pattern and exposes them in the Prometheus text format:
+
+	m := metrics.New()
+	mux := yam.New()
+	mux.Use(m.Middleware)
+	mux.Route("/metrics").Get(m.Handler())
+
+Tracing
+
+The tracing package turns each request into a W3C Trace Context span named after the matched route,
+for example "GET /users/:id", continuing any trace from the traceparent and tracestate headers:
+
+	tracer := tracing.New(tracing.NewStdoutExporter(os.Stdout))
+	mux := yam.New()
+	mux.Use(tracer.Middleware)
+
+Access Logging
+
+The accesslog package logs requests with their route pattern and values, status, size, duration, client
+IP and request ID in Common Log Format, JSON or logfmt, or through log/slog. Routes can opt out:
+
+	mux := yam.New()
+	mux.Use(accesslog.Middleware(accesslog.NewWriter(os.Stdout, accesslog.JSON)))
+	mux.Route("/health").Get(health).Meta(accesslog.Skip, true)
+
+Authentication
+
+The auth package authenticates routes, or whole subtrees, with HTTP Basic, opaque bearer tokens or JWTs
+signed with HS256, RS256 or ES256 keys from a JWKS file. Failures are replied to with a 401 and a
+WWW-Authenticate challenge, the principal is placed on the request context and OPTIONS requests are not
+authenticated:
+
+	keys, _ := auth.LoadJWKS("jwks.json")
+	api := mux.Route("/api").Use(auth.Middleware(auth.JWT("api", keys, auth.JWTOptions{Audience: "api"})))
+	api.Route("/me").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(auth.FromContext(r.Context()).Subject))
+	}))
+
+Authorization
+
+Require declares permissions or role expressions requests to a route and the routes under it must
+satisfy, RequireMethod declares them for one method. Config.Authorizer checks them after the route
+middleware and before the handler, replying with a 403 when they are not satisfied. The auth package
+has an in memory RBAC Authorizer, and WritePermissions dumps the requirements of the whole tree:
+
+	mux.Config.Authorizer = auth.NewRBAC().Grant("clerk", "orders:read", "orders:write")
+	orders := mux.Route("/orders").Use(auth.Middleware(jwt)).Require("orders:read")
+	orders.RequireMethod("POST", "orders:write").RequireMethod("DELETE", "role:admin")
+	mux.WritePermissions(os.Stdout)
+
+CSRF Protection
+
+The csrf package protects routes authenticated with cookies. Requests are given a signed token cookie,
+unsafe requests must send the token back in the X-CSRF-Token header or the csrf_token form field and
+are refused with a 403 when Origin or Sec-Fetch-Site show they come from another site. Token and
+TemplateField expose the token to templates, and subtrees authenticated with tokens can be exempted:
+
+	mux.Use(csrf.New(key).Middleware)
+	mux.Route("/account").Get(account).Post(updateAccount)
+	mux.Route("/api").Meta(csrf.Exempt, true)
+
 Pattern Matching
 
 YAM implements a very simple "/foo/:bar" pattern matching system, values from those patterns
@@ -93,6 +196,13 @@ required. The values persist down the path.
 
 	http.ListenAndServe(":5000", mux)
 
+A "*name" segment at the end of a pattern matches the rest of the path, it is used when no other route
+at its depth matches:
+
+	mux.Route("/files/*path").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Query().Get("*path")))
+	})
+
 Methods
 
 YAM supports all the standard HTTP verbs, with the exception of CONNECT (https://en.wikipedia.org/wiki/Hypertext_Transfer_Protocol#Request_methods).
@@ -156,6 +266,213 @@ The above would register the following routes.
 	POST & DELETE /foo/bar
 	PUT /foo/bar/baz
 
+Route Information
+
+The route that matched a request, its full pattern, pattern values and metadata are available to handlers
+and middleware through CurrentRoute. Metadata set with Meta is inherited by the routes below it.
+
+	mux := yam.New()
+	admin := mux.Route("/admin").Meta("scope", "admin")
+	admin.Route("/users/:id").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := yam.CurrentRoute(r)
+		fmt.Fprintln(w, info.Pattern, info.Meta["scope"]) // "/admin/users/:id admin"
+	}))
+
+Middleware wrapping the mux can read the route after the mux has served the request by preparing the
+request with WithRouteInfo first, this is useful for labelling logs and metrics by route pattern rather
+than by path.
+
+Errors
+
+YAM's own "404 Not Found" and "405 Method Not Allowed" responses are RFC 7807 Problem Details, rendered as
+"application/problem+json", HTML or plain text depending on the requests Accept header. Handlers can use the
+same format by returning a *Problem from a HandlerFunc:
+
+	mux := yam.New()
+	mux.Route("/orders/:id").Get(yam.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		order, err := find(r.URL.Query().Get(":id"))
+		if err == ErrNoOrder {
+			return yam.NewProblem(http.StatusNotFound, "no such order")
+		}
+		if err != nil {
+			return err // Rendered as a 500 without exposing the error message
+		}
+		return json.NewEncoder(w).Encode(order)
+	}))
+
+Errors returned from a HandlerFunc, and errors the mux replies with, are passed to the ErrorHandler of the
+Config, which can be replaced to customise error responses. Plain http.Handlers can call yam.Error to use it.
+
+Request IDs
+
+When Config.RequestID is enabled each request is given an ID, taken from the X-Request-ID header, or the
+trace-id of a W3C traceparent header, or generated when neither is present. The ID is echoed on the response,
+available to handlers through RequestID and included in error responses and the default TRACE response.
+
+Route Middleware
+
+Middleware can be added to a route with Use, it wraps the handlers of the route and of every route under it:
+
+	mux := yam.New()
+	admin := mux.Route("/admin").Use(RequireAdmin)
+	admin.Route("/users").Get(listUsers)
+
+The handlers are wrapped once, the first time each route and method is served, so routes should be
+configured before the mux serves requests.
+
+Rate Limiting
+
+A RateLimiter limits the number of requests per window for each client, keyed by IP address, API key or
+any other function of the request. Requests over the limit are replied to with a 429 and RateLimit headers
+are added to responses. Counts are kept in memory by default, other stores can implement RateLimitStore:
+
+	mux := yam.New()
+	mux.Route("/login").RateLimit(yam.NewRateLimiter(5, time.Minute)).Post(login)
+
+	limiter := yam.NewRateLimiter(1000, time.Hour)
+	limiter.Key = yam.KeyByHeader("X-API-Key")
+	mux.Route("/api").Use(limiter.Middleware)
+
+Concurrency Limits
+
+MaxConcurrent limits how many requests a route serves at a time, with a bounded queue of requests waiting
+for their turn, so an expensive endpoint can not starve the rest of the API. LoadShed attaches an adaptive
+limiter which lowers its limit when requests get slow and rejects requests over it early. Both reply with
+a 503, and the load on a route can be inspected with Concurrency:
+
+	mux := yam.New()
+	reports := mux.Route("/reports/:id").MaxConcurrent(4, 16, time.Second).Get(report)
+	mux.Route("/search").LoadShed(yam.NewAdaptiveLimiter(10, 200, 100*time.Millisecond)).Get(search)
+	log.Println(reports.Concurrency().Queued)
+
+Timeouts
+
+Routes can be given a timeout with Timeout, which also applies to the routes under them unless they set
+their own, and Config.Timeout sets a default for the whole mux. The request context carries the deadline
+and a handler that overruns is replied to with a 503 through the error handler. Responses are buffered
+while a timeout applies, so streaming routes should disable it with a zero duration:
+
+	mux := yam.New()
+	mux.Config.Timeout = 5 * time.Second
+	mux.Route("/search").Timeout(200 * time.Millisecond).Get(search)
+	mux.Route("/events").Timeout(0).Get(events)
+
+Body Limits
+
+Config.MaxBodyBytes limits the size of request bodies for the whole mux and MaxBody overrides it for a
+route and the routes under it. Requests with a Content-Length over the limit are replied to with a 413
+straight away, other bodies are wrapped with http.MaxBytesReader and a HandlerFunc returning the read
+error gets a 413 from the error handler:
+
+	mux := yam.New()
+	mux.Config.MaxBodyBytes = 64 << 10
+	mux.Route("/uploads").MaxBody(100 << 20).Post(upload)
+
+Compression
+
+Setting Config.Compression compresses responses with the encoding best matching the Accept-Encoding
+header, gzip and deflate are supported by default and others can be added with Register. Responses
+under MinSize, already encoded, partial or of a denylisted type such as images are sent as is. Routes
+can opt out, or opt in when the mux does not compress, with Compress:
+
+	mux := yam.New()
+	mux.Config.Compression = yam.NewCompressor()
+	mux.Route("/downloads").Compress(false).Get(download)
+
+Decompression
+
+Decompress enables decompression of gzip and deflate request bodies for a route and the routes under
+it. The handler reads the decompressed body with the Content-Encoding header removed. The decompressed
+size is capped to guard against zip bombs and requests with other encodings are replied to with a 415:
+
+	mux.Route("/ingest").Decompress(10 << 20).Post(ingest)
+
+ETags
+
+Config.ETag generates strong or weak ETags by hashing the buffered responses of GET routes, and ETag
+overrides it for a route and the routes under it. If-None-Match and If-Modified-Since are answered with
+a 304, for HEAD requests too. Other methods with If-Match or If-Unmodified-Since are checked against the
+GET response of the route and replied to with a 412 when it has changed. Compressed responses keep a
+strong ETag naming the encoding, which preconditions accept:
+
+	mux := yam.New()
+	mux.Config.ETag = yam.WeakETag
+	mux.Route("/docs/:id").ETag(yam.StrongETag).Get(doc).Put(update)
+
+Caching
+
+Cache keeps GET responses of a route and the routes under it in Config.Cache, an in memory LRU keyed by
+method, path, query and the request headers in Vary. Cache-Control directives of requests and responses
+are respected, and stale responses can be served while they are refreshed in the background. Cached
+responses can be purged by route name or path prefix:
+
+	mux := yam.New()
+	mux.Route("/reports").Named("reports").Cache(30*time.Second, yam.StaleWhileRevalidate(time.Minute)).Get(reports)
+	mux.Config.Cache.Purge("reports")
+	mux.Config.Cache.PurgePrefix("/reports/")
+
+Coalescing
+
+Coalesce lets concurrent identical GET and HEAD requests to a route share one execution of the handler,
+its response is replayed to every waiting request. The handler keeps running when the first request is
+cancelled as long as other requests are waiting on it:
+
+	mux.Route("/catalog/:id").Coalesce(true).Cache(30 * time.Second).Get(product)
+
+Idempotency
+
+Idempotent stores the first response of POST and PATCH requests for each Idempotency-Key header, scoped
+by client and route, and replays it for retries. Reusing a key with a different request is replied to
+with a 409 and retrying while the first request is in flight with a 422. Request bodies are read to
+fingerprint requests so they are limited to 1MiB by default, larger bodies get a 413. Responses are kept
+in memory by default, an IdempotencyStore backed by shared storage can be used instead:
+
+	mux.Route("/payments").Idempotent(yam.NewIdempotency(24 * time.Hour)).Post(pay)
+
+Static Files
+
+Static serves files from a fs.FS, such as an embed.FS, on a catch-all route with Range and conditional
+request support. Files with a content hash in their name are cached as immutable, precompressed .br and
+.gz files can be served in their place, and in SPA mode unknown paths fall back to index.html:
+
+	assets, _ := fs.Sub(dist, "dist")
+	mux.Route("/api/users").Get(users)
+	mux.Route("/*path").Static(assets, &yam.StaticOptions{SPA: true, Precompressed: true})
+
+Security Headers
+
+Config.SecurityHeaders sets HSTS, Content-Security-Policy, X-Content-Type-Options, X-Frame-Options,
+Referrer-Policy, Permissions-Policy and the Cross-Origin policies on every response, including YAM's own
+404, 405, OPTIONS and TRACE responses. Routes can set their own policy for their subtree, or none, and a
+{nonce} in the policy is replaced with a nonce for each request, available from CSPNonce. Responses to
+requests with a nonce are neither cached nor coalesced:
+
+	mux.Config.SecurityHeaders = yam.NewSecurityHeaders()
+	mux.Config.SecurityHeaders.ContentSecurityPolicy = "script-src 'self' 'nonce-{nonce}'"
+	mux.Route("/widgets").SecurityHeaders(&yam.SecurityHeaders{FrameOptions: "SAMEORIGIN"})
+
+Client IPs
+
+ClientIP returns the IP address of the client. Forwarded and X-Forwarded-For are only believed when the
+request comes from one of Config.TrustedProxies, and the client is the nearest forwarded address that is
+not a trusted proxy. KeyByIP, the access log and tracing use it. AllowIPs and DenyIPs restrict a route and
+the routes under it, replying with a 403:
+
+	mux.Config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	mux.Route("/admin").AllowIPs(netip.MustParsePrefix("203.0.113.0/24"), netip.MustParsePrefix("10.8.0.0/16"))
+
+Redirects
+
+Config.RedirectHTTPS redirects plain HTTP requests to HTTPS and Config.CanonicalHost redirects requests
+for other hosts, such as www., to the canonical host. The path and query are kept, GET and HEAD requests
+are given a 301 and other methods a 308 so they are repeated with their body. The scheme is read from
+X-Forwarded-Proto or Forwarded when the request comes from a trusted proxy, see Scheme, and routes can
+opt out:
+
+	mux.Config.RedirectHTTPS = true
+	mux.Config.CanonicalHost = "example.com"
+	mux.Route("/health").Redirects(false).Get(health)
+
 Configuration
 
 Finally if you do not like any of the default settings of "YAM", you can change them! The Config type
@@ -177,6 +494,15 @@ allows this:
 		})
 	} // Set a custom handler function for TRACE when config.Trace is true
 	config.AddHeadOnGet = false // HEAD support will not longer be added by default on Get
+	config.Recover = true // Panics in handlers are recovered and replied to with a 500
+	config.PanicHandler = func(r *http.Request, p *yam.Panic) {
+		log.Printf("panic in %s: %v\n%s", p.Pattern, p.Value, p.Stack)
+	} // Set a custom reporter for recovered panics when config.Recover is true
+	config.RequestID = true // Requests are given an ID, available with yam.RequestID
+	config.RequestIDHeader = "X-Correlation-ID" // Header the ID is read from and echoed on, X-Request-ID by default
+	config.RequestIDGenerator = func() string {
+		return uuid.New().String()
+	} // Set a custom ID generator used when a request has no ID
 	mux.Config = config
 
 */
@@ -0,0 +1,344 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bufio"
+	"encoding/hex"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How ETags are generated for the responses of a route
+type ETagMode int
+
+const (
+	// ETags are not generated and conditional requests are left to the
+	// handlers
+	NoETag ETagMode = iota
+	// Strong ETags, responses with the same ETag are byte for byte identical
+	StrongETag
+	// Weak ETags, responses with the same ETag are equivalent
+	WeakETag
+)
+
+// Sets how ETags are generated for the responses of the route, and of the
+// routes under it unless they set their own, overriding Config.ETag.
+//
+//	mux.Config.ETag = yam.WeakETag
+//	mux.Route("/downloads").ETag(yam.StrongETag).Get(download)
+//	mux.Route("/events").ETag(yam.NoETag).Get(events)
+//
+// Successful GET and HEAD responses are buffered and hashed to generate an
+// ETag, unless the handler set one itself, and If-None-Match and
+// If-Modified-Since, using the Last-Modified header set by the handler, are
+// answered with a 304 Not Modified. Requests with other methods carrying
+// If-Match, If-Unmodified-Since or If-None-Match are checked against the
+// response of the GET handler of the route, and replied to with a 412
+// Precondition Failed when it has changed, for optimistic concurrency.
+// Responses that are flushed are streamed without an ETag.
+func (r *Route) ETag(mode ETagMode) *Route {
+	r.etag = &mode
+
+	return r
+}
+
+// Returns the ETag mode for the route, set on the route or inherited from the
+// routes above it, falling back to Config.ETag
+func (r *Route) effectiveETag() ETagMode {
+	for route := r; route != nil; route = route.parent {
+		if route.etag != nil {
+			return *route.etag
+		}
+	}
+
+	return r.yam.Config.ETag
+}
+
+// Generates ETags for responses and evaluates the preconditions of requests
+func etagHandler(next http.Handler, route *Route, mode ETagMode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if get, ok := route.handlers[http.MethodGet]; ok && hasPreconditions(r) {
+				etag, modified, exists := current(get, r, mode)
+				if evaluate(r, etag, modified, exists) != 0 {
+					Error(w, r, NewProblem(http.StatusPreconditionFailed, "the resource has been modified"))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		// A HEAD handler of its own does not write the body of the GET
+		// response so an ETag can not be generated from it
+		if r.Method == http.MethodHead && !route.headFromGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &etagWriter{ResponseWriter: w, mode: mode}
+		next.ServeHTTP(ew.wrap(), r)
+		ew.finish(r)
+	})
+}
+
+// Returns whether the request has any conditional headers
+func hasPreconditions(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" ||
+		r.Header.Get("If-None-Match") != "" ||
+		r.Header.Get("If-Unmodified-Since") != ""
+}
+
+// Returns the ETag and last modified time of the current representation of
+// the resource by serving a GET request to the handler, exists is false when
+// the handler does not reply with a 200
+func current(get http.Handler, r *http.Request, mode ETagMode) (etag string, modified time.Time, exists bool) {
+	req := r.Clone(r.Context())
+	req.Method = http.MethodGet
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	for _, k := range []string{
+		"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range",
+		"Content-Length", "Content-Type", "Content-Encoding",
+	} {
+		req.Header.Del(k)
+	}
+
+	ew := &etagWriter{ResponseWriter: discardWriter{http.Header{}}, mode: mode}
+	get.ServeHTTP(ew, req)
+	if ew.passthrough || (ew.status != 0 && ew.status != http.StatusOK) {
+		return "", time.Time{}, false
+	}
+	modified, _ = http.ParseTime(ew.Header().Get("Last-Modified"))
+
+	return ew.etag(), modified, true
+}
+
+// Evaluates the preconditions of a request in the order of RFC 9110 section
+// 13.2.2, returning 304 or 412 when they fail and 0 otherwise
+func evaluate(r *http.Request, etag string, modified time.Time, exists bool) int {
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !exists || !matchETag(im, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && exists && !modified.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && modified.Truncate(time.Second).After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if exists && matchETag(inm, etag, true) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && safe && exists && !modified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !modified.Truncate(time.Second).After(t) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// Returns whether an If-Match or If-None-Match header matches an ETag, using
+// the weak comparison when weak is true and the strong comparison otherwise
+func matchETag(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if etag == "" || (!weak && strings.HasPrefix(etag, "W/")) {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = withoutEncoding(strings.TrimSpace(tag))
+		if weak && strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+		if !weak && tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns an ETag generated by YAM without the content coding the compressor
+// added to it, "<hash>-gzip" becomes "<hash>". Other ETags are returned as is.
+func withoutEncoding(tag string) string {
+	weak := strings.HasPrefix(tag, "W/")
+	t := strings.TrimPrefix(tag, "W/")
+	if len(t) < 36 || t[0] != '"' || t[33] != '-' || t[len(t)-1] != '"' {
+		return tag
+	}
+	if _, err := hex.DecodeString(t[1:33]); err != nil {
+		return tag
+	}
+
+	t = t[:33] + `"`
+	if weak {
+		t = "W/" + t
+	}
+
+	return t
+}
+
+// Buffers successful responses so an ETag can be generated before the header
+// is written
+type etagWriter struct {
+	http.ResponseWriter
+	mode        ETagMode
+	status      int
+	buf         []byte
+	passthrough bool // The response is not buffered
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	if code != http.StatusOK {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+
+	return len(b), nil
+}
+
+// Returns the ETag set by the handler or generated from the body
+func (w *etagWriter) etag() string {
+	if etag := w.Header().Get("ETag"); etag != "" {
+		return etag
+	}
+
+	h := fnv.New128a()
+	h.Write(w.buf)
+	etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+	if w.mode == WeakETag {
+		etag = "W/" + etag
+	}
+
+	return etag
+}
+
+// Writes the header and the buffered body, the response is streamed from then
+// on
+func (w *etagWriter) flushBuffer() {
+	w.passthrough = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) > 0 {
+		w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+}
+
+// Finishes the response once the handler has returned, replying with a 304 or
+// 412 when the preconditions of the request fail
+func (w *etagWriter) finish(r *http.Request) {
+	if w.passthrough {
+		return
+	}
+
+	h := w.Header()
+	if cw, ok := r.Context().Value(compressKey).(*compressWriter); ok && h.Get("ETag") == "" {
+		cw.generatedETag = true
+	}
+	etag := w.etag()
+	h.Set("ETag", etag)
+	modified, _ := http.ParseTime(h.Get("Last-Modified"))
+
+	switch evaluate(r, etag, modified, true) {
+	case http.StatusNotModified:
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+	case http.StatusPreconditionFailed:
+		h.Del("ETag")
+		h.Del("Last-Modified")
+		Error(w.ResponseWriter, r, NewProblem(http.StatusPreconditionFailed, "the resource has been modified"))
+	default:
+		if h.Get("Content-Length") == "" {
+			h.Set("Content-Length", strconv.Itoa(len(w.buf)))
+		}
+		w.flushBuffer()
+	}
+}
+
+func (w *etagWriter) Flush() {
+	if !w.passthrough {
+		w.flushBuffer()
+	}
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *etagWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.passthrough = true
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// Returns the underlying writer for http.ResponseController
+func (w *etagWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Returns the writer implementing http.Flusher and http.Hijacker when the
+// underlying writer does
+func (w *etagWriter) wrap() http.ResponseWriter {
+	_, isFlusher := w.ResponseWriter.(http.Flusher)
+	_, isHijacker := w.ResponseWriter.(http.Hijacker)
+
+	switch {
+	case isFlusher && isHijacker:
+		return w
+	case isFlusher:
+		return struct {
+			unwrapper
+			http.Flusher
+		}{w, w}
+	case isHijacker:
+		return struct {
+			unwrapper
+			http.Hijacker
+		}{w, w}
+	}
+
+	return struct{ unwrapper }{w}
+}
+
+// A http.ResponseWriter discarding the response
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header         { return w.header }
+func (w discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardWriter) WriteHeader(int)             {}
@@ -0,0 +1,275 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestETag(t *testing.T) {
+	body := "Hello World"
+	mux := New()
+	mux.Config.ETag = StrongETag
+	mux.Route("/strong").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	mux.Route("/weak").ETag(WeakETag).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	mux.Route("/none").ETag(NoETag).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	mux.Route("/missing").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+
+	serve := func(method, path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	strong := serve("GET", "/strong").Header().Get("ETag")
+	if !strings.HasPrefix(strong, `"`) {
+		t.Fatalf("ETag was %q, should be strong", strong)
+	}
+	weak := serve("GET", "/weak").Header().Get("ETag")
+	if weak != "W/"+strong {
+		t.Errorf("ETag was %q, should be %q", weak, "W/"+strong)
+	}
+	if etag := serve("GET", "/none").Header().Get("ETag"); etag != "" {
+		t.Errorf("ETag was %q, routes can opt out", etag)
+	}
+	if etag := serve("GET", "/missing").Header().Get("ETag"); etag != "" {
+		t.Errorf("ETag was %q, only successful responses are tagged", etag)
+	}
+
+	var tests = []struct {
+		method      string
+		path        string
+		ifNoneMatch string
+		status      int
+	}{
+		{"GET", "/strong", "", http.StatusOK},
+		{"GET", "/strong", strong, http.StatusNotModified},
+		{"GET", "/strong", `"other", ` + strong, http.StatusNotModified},
+		{"GET", "/strong", "W/" + strong, http.StatusNotModified},
+		{"GET", "/strong", "*", http.StatusNotModified},
+		{"GET", "/strong", `"other"`, http.StatusOK},
+		{"GET", "/weak", strong, http.StatusNotModified},
+		{"HEAD", "/strong", strong, http.StatusNotModified},
+		{"HEAD", "/strong", `"other"`, http.StatusOK},
+		{"GET", "/none", strong, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		res := serve(test.method, test.path, "If-None-Match", test.ifNoneMatch)
+		if res.Code != test.status {
+			t.Errorf("%s %s %q: Status was %d, should be %d", test.method, test.path, test.ifNoneMatch, res.Code, test.status)
+		}
+		if test.status == http.StatusNotModified && res.Body.Len() != 0 {
+			t.Errorf("%s %s: 304 should not have a body", test.method, test.path)
+		}
+		if test.status == http.StatusOK && test.method == "GET" && res.Body.String() != body {
+			t.Errorf("%s %s: Body was %q, should be %q", test.method, test.path, res.Body.String(), body)
+		}
+	}
+
+	head := serve("HEAD", "/strong")
+	if head.Header().Get("ETag") != strong || head.Header().Get("Content-Length") != "11" {
+		t.Errorf("HEAD ETag was %q with Content-Length %q, should match GET", head.Header().Get("ETag"), head.Header().Get("Content-Length"))
+	}
+}
+
+func TestETagHead(t *testing.T) {
+	mux := New()
+	mux.Route("/").ETag(StrongETag).
+		Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello world"))
+		})).
+		Head(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "11")
+		}))
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("HEAD", "/", nil))
+
+	// The HEAD handler is left to set its own headers
+	if cl := res.Header().Get("Content-Length"); cl != "11" {
+		t.Errorf("Content-Length was %q, should be %q", cl, "11")
+	}
+	if etag := res.Header().Get("ETag"); etag != "" {
+		t.Errorf("ETag was %q, should not be generated from an empty body", etag)
+	}
+}
+
+func TestETagLastModified(t *testing.T) {
+	modified := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	mux := New()
+	mux.Route("/").ETag(StrongETag).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.Write([]byte("Hello World"))
+	}))
+
+	var tests = []struct {
+		header string
+		value  string
+		status int
+	}{
+		{"If-Modified-Since", modified.Format(http.TimeFormat), http.StatusNotModified},
+		{"If-Modified-Since", modified.Add(time.Hour).Format(http.TimeFormat), http.StatusNotModified},
+		{"If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+		{"If-None-Match", `"v1"`, http.StatusNotModified},
+		{"If-Match", `"v1"`, http.StatusOK},
+		{"If-Match", `"v0"`, http.StatusPreconditionFailed},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(test.header, test.value)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%s %q: Status was %d, should be %d", test.header, test.value, res.Code, test.status)
+		}
+		if res.Code == http.StatusOK && res.Header().Get("ETag") != `"v1"` {
+			t.Errorf("ETag was %q, the handler ETag should be kept", res.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestETagPreconditions(t *testing.T) {
+	modified := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	var updated bool
+	mux := New()
+	mux.Route("/doc").ETag(StrongETag).
+		Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+			w.Write([]byte("version 1"))
+		})).
+		Put(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			updated = true
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	mux.Route("/new").ETag(StrongETag).
+		Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		})).
+		Put(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			updated = true
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+	req := httptest.NewRequest("GET", "/doc", nil)
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	etag := res.Header().Get("ETag")
+
+	var tests = []struct {
+		path   string
+		header string
+		value  string
+		status int
+	}{
+		{"/doc", "", "", http.StatusNoContent},
+		{"/doc", "If-Match", etag, http.StatusNoContent},
+		{"/doc", "If-Match", "*", http.StatusNoContent},
+		{"/doc", "If-Match", `"stale"`, http.StatusPreconditionFailed},
+		{"/doc", "If-Match", "W/" + etag, http.StatusPreconditionFailed},
+		{"/doc", "If-Unmodified-Since", modified.Format(http.TimeFormat), http.StatusNoContent},
+		{"/doc", "If-Unmodified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), http.StatusPreconditionFailed},
+		{"/doc", "If-None-Match", "*", http.StatusPreconditionFailed},
+		{"/new", "If-None-Match", "*", http.StatusCreated},
+		{"/new", "If-Match", "*", http.StatusPreconditionFailed},
+	}
+
+	for _, test := range tests {
+		updated = false
+		req := httptest.NewRequest("PUT", test.path, strings.NewReader("version 2"))
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%s %s %q: Status was %d, should be %d", test.path, test.header, test.value, res.Code, test.status)
+		}
+		if updated != (test.status != http.StatusPreconditionFailed) {
+			t.Errorf("%s %s %q: Handler called was %v", test.path, test.header, test.value, updated)
+		}
+	}
+}
+
+func TestETagCompression(t *testing.T) {
+	mux := New()
+	mux.Config.Compression = NewCompressor()
+	mux.Route("/").ETag(StrongETag).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(large))
+	})).Put(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	identity := res.Header().Get("ETag")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	// Generated ETags stay strong and name the encoding
+	etag := res.Header().Get("ETag")
+	if etag != strings.TrimSuffix(identity, `"`)+`-gzip"` {
+		t.Fatalf("ETag was %q, should be %q with the encoding", etag, identity)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", etag)
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	if res.Code != http.StatusNotModified {
+		t.Errorf("Status was %d, should be %d", res.Code, http.StatusNotModified)
+	}
+	if res.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Content-Encoding was %q, 304 should not be compressed", res.Header().Get("Content-Encoding"))
+	}
+
+	// The ETag of a compressed response can be used for optimistic concurrency
+	var tests = []struct {
+		ifMatch string
+		status  int
+	}{
+		{etag, http.StatusNoContent},
+		{identity, http.StatusNoContent},
+		{"W/" + etag, http.StatusPreconditionFailed},
+		{`"0123456789abcdef0123456789abcdef-gzip"`, http.StatusPreconditionFailed},
+	}
+
+	for _, test := range tests {
+		req = httptest.NewRequest("PUT", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("If-Match", test.ifMatch)
+		res = httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("If-Match %s: Status was %d, should be %d", test.ifMatch, res.Code, test.status)
+		}
+	}
+}
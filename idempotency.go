@@ -0,0 +1,317 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Makes retries of POST and PATCH requests with the same Idempotency-Key
+// header safe by replaying the response of the first request. Attach it to a
+// route with Route.Idempotent.
+type Idempotency struct {
+	TTL time.Duration // How long responses are kept for replay, DefaultIdempotencyTTL when not set
+
+	// Largest request body read to fingerprint a request,
+	// DefaultIdempotencyMaxBody when not set. Larger bodies are replied to
+	// with a 413 Content Too Large.
+	MaxBody int64
+
+	// Requests without an Idempotency-Key header are replied to with a 400
+	// Bad Request when true, and served as usual otherwise
+	Required bool
+
+	// Returns the client key idempotency keys are scoped to, KeyByIP by
+	// default
+	Key func(*http.Request) string
+
+	// Store keeping responses, a MemoryIdempotencyStore of the Idempotency
+	// by default. Requests are replied to with a 503 Service Unavailable if
+	// the store fails, since serving them could repeat a request.
+	Store IdempotencyStore
+
+	once         sync.Once
+	defaultStore *MemoryIdempotencyStore
+}
+
+// Defaults for an Idempotency without a TTL or MaxBody
+const (
+	DefaultIdempotencyTTL     = 24 * time.Hour
+	DefaultIdempotencyMaxBody = 1 << 20
+)
+
+// Constructs a new Idempotency keeping responses in memory for ttl, scoped to
+// the client IP
+func NewIdempotency(ttl time.Duration) *Idempotency {
+	return &Idempotency{
+		TTL:   ttl,
+		Key:   KeyByIP,
+		Store: NewMemoryIdempotencyStore(),
+	}
+}
+
+// Stores the requests and responses of idempotency keys. Implementations
+// backed by shared storage allow retries to be sent to any instance.
+type IdempotencyStore interface {
+	// Reserves the key for a request with a fingerprint, returning nil if it
+	// was reserved and the existing record otherwise. Reservations and
+	// records expire after ttl.
+	Reserve(key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error)
+
+	// Stores the completed response for a reserved key
+	Save(key string, record *IdempotencyRecord, ttl time.Duration) error
+
+	// Removes the reservation of a key so the request can be retried
+	Release(key string) error
+}
+
+// The request and response of an idempotency key
+type IdempotencyRecord struct {
+	Fingerprint string // Hash of the method, URL and body of the request
+	Done        bool   // The response is complete, false while the request is in flight
+
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Returns the store of the Idempotency, creating a MemoryIdempotencyStore the
+// first time it is needed when none is set
+func (i *Idempotency) store() IdempotencyStore {
+	if i.Store != nil {
+		return i.Store
+	}
+	i.once.Do(func() {
+		i.defaultStore = NewMemoryIdempotencyStore()
+	})
+
+	return i.defaultStore
+}
+
+// Disables idempotency on a route with Route.Idempotent(nil)
+var noIdempotency = &Idempotency{}
+
+// Makes POST and PATCH requests to the route, and to the routes under it
+// unless they set their own, idempotent using the Idempotency-Key header. A
+// nil Idempotency disables it.
+//
+//	mux.Route("/payments").Idempotent(yam.NewIdempotency(24 * time.Hour)).Post(pay)
+//
+// Keys are scoped to the client and to the route pattern. The first response
+// for a key is stored and replayed for retries with an Idempotent-Replayed
+// header. Reusing a key for a request with a different method, URL or body
+// is replied to with a 409 Conflict, and a retry while the first request is
+// still in flight with a 422 Unprocessable Content. Server errors, panics and
+// flushed responses are not stored so the request can be retried.
+func (r *Route) Idempotent(i *Idempotency) *Route {
+	if i == nil {
+		i = noIdempotency
+	}
+	r.idempotency = i
+
+	return r
+}
+
+// Returns the idempotency for the route, set on the route or inherited from
+// the routes above it, nil if requests are not idempotent
+func (r *Route) effectiveIdempotency() *Idempotency {
+	for route := r; route != nil; route = route.parent {
+		if route.idempotency != nil {
+			if route.idempotency == noIdempotency {
+				return nil
+			}
+			return route.idempotency
+		}
+	}
+
+	return nil
+}
+
+// Middleware storing and replaying responses by idempotency key, keys are
+// scoped to scope
+func (i *Idempotency) handler(next http.Handler, scope string) http.Handler {
+	keyFunc := i.Key
+	if keyFunc == nil {
+		keyFunc = KeyByIP
+	}
+	store := i.store()
+	ttl := i.TTL
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	maxBody := i.MaxBody
+	if maxBody <= 0 {
+		maxBody = DefaultIdempotencyMaxBody
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPatch {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The header is a structured field string, which is quoted
+		idempotencyKey := strings.Trim(r.Header.Get("Idempotency-Key"), `"`)
+		if idempotencyKey == "" {
+			if i.Required {
+				Error(w, r, NewProblem(http.StatusBadRequest, "the Idempotency-Key header is required"))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validRequestID(idempotencyKey) {
+			Error(w, r, NewProblem(http.StatusBadRequest, "the Idempotency-Key header is not valid"))
+			return
+		}
+
+		var body []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			var err error
+			// The body is held in memory so it has to be limited
+			if body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody)); err != nil {
+				Error(w, r, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		h := sha256.New()
+		h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+		h.Write(body)
+		fingerprint := hex.EncodeToString(h.Sum(nil))
+
+		key := scope + "\x00" + keyFunc(r) + "\x00" + idempotencyKey
+		record, err := store.Reserve(key, fingerprint, ttl)
+		switch {
+		case err != nil:
+			Error(w, r, NewProblem(http.StatusServiceUnavailable, ""))
+			return
+		case record != nil && record.Fingerprint != fingerprint:
+			Error(w, r, NewProblem(http.StatusConflict, "the Idempotency-Key was used for a different request"))
+			return
+		case record != nil && !record.Done:
+			w.Header().Set("Retry-After", "1")
+			Error(w, r, NewProblem(http.StatusUnprocessableEntity, "a request with the Idempotency-Key is in progress"))
+			return
+		case record != nil:
+			dst := w.Header()
+			for k, v := range record.Header {
+				dst[k] = append(dst[k], v...)
+			}
+			dst.Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.Status)
+			w.Write(record.Body)
+			return
+		}
+
+		saved := false
+		defer func() {
+			if !saved {
+				// Let the client retry, the panic carries on to be recovered
+				store.Release(key)
+			}
+		}()
+
+		cw := &cacheWriter{ResponseWriter: w, pre: w.Header().Clone(), max: math.MaxInt}
+		next.ServeHTTP(cw.wrap(), r)
+		if cw.status == 0 {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if cw.skip || cw.status >= 500 {
+			return
+		}
+
+		saved = store.Save(key, &IdempotencyRecord{
+			Fingerprint: fingerprint,
+			Done:        true,
+			Status:      cw.status,
+			Header:      cw.header,
+			Body:        cw.body,
+		}, ttl) == nil
+	})
+}
+
+// In memory IdempotencyStore. Expired keys are removed at most once a minute.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]*idempotencyEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type idempotencyEntry struct {
+	record  *IdempotencyRecord
+	expires time.Time
+}
+
+// Constructs a new empty MemoryIdempotencyStore
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+// Implements the IdempotencyStore interface
+func (s *MemoryIdempotencyStore) Reserve(key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if e, ok := s.records[key]; ok && now.Before(e.expires) {
+		return e.record, nil
+	}
+	s.records[key] = &idempotencyEntry{
+		record:  &IdempotencyRecord{Fingerprint: fingerprint},
+		expires: now.Add(ttl),
+	}
+
+	return nil, nil
+}
+
+// Implements the IdempotencyStore interface
+func (s *MemoryIdempotencyStore) Save(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = &idempotencyEntry{record: record, expires: s.now().Add(ttl)}
+
+	return nil
+}
+
+// Implements the IdempotencyStore interface
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
+
+// Removes expired keys, at most once a minute
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, e := range s.records {
+		if !now.Before(e.expires) {
+			delete(s.records, key)
+		}
+	}
+}
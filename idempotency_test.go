@@ -0,0 +1,255 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	var calls int32
+	pay := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Location", "/payments/"+strconv.Itoa(int(n)))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(b)
+	})
+
+	mux := New()
+	mux.Config.RequestID = true
+	payments := mux.Route("/payments").Idempotent(NewIdempotency(time.Hour)).Post(pay)
+	payments.Route("/:id").Patch(pay).Put(pay).Route("/export").Idempotent(nil).Post(pay)
+	mux.Route("/refunds").Idempotent(&Idempotency{TTL: time.Hour}).Post(pay)
+	mux.Route("/transfers").Idempotent(&Idempotency{TTL: time.Hour, Required: true}).Post(pay)
+
+	var tests = []struct {
+		name     string
+		method   string
+		path     string
+		key      string
+		addr     string
+		body     string
+		status   int
+		replayed bool
+		calls    int32
+	}{
+		{"first", "POST", "/payments", "a", "", "100", http.StatusCreated, false, 1},
+		{"retry", "POST", "/payments", "a", "", "100", http.StatusCreated, true, 1},
+		{"quoted retry", "POST", "/payments", `"a"`, "", "100", http.StatusCreated, true, 1},
+		{"different body", "POST", "/payments", "a", "", "200", http.StatusConflict, false, 1},
+		{"different client", "POST", "/payments", "a", "192.0.2.2:1234", "100", http.StatusCreated, false, 2},
+		{"different route", "POST", "/refunds", "a", "", "100", http.StatusCreated, false, 3},
+		{"default store retry", "POST", "/refunds", "a", "", "100", http.StatusCreated, true, 3},
+		{"no key", "POST", "/payments", "", "", "100", http.StatusCreated, false, 4},
+		{"no key again", "POST", "/payments", "", "", "100", http.StatusCreated, false, 5},
+		{"invalid key", "POST", "/payments", "a b", "", "100", http.StatusBadRequest, false, 5},
+		{"required", "POST", "/transfers", "", "", "100", http.StatusBadRequest, false, 5},
+		{"patch", "PATCH", "/payments/1", "b", "", "100", http.StatusCreated, false, 6},
+		{"patch retry", "PATCH", "/payments/1", "b", "", "100", http.StatusCreated, true, 6},
+		{"patch other URL", "PATCH", "/payments/2", "b", "", "100", http.StatusConflict, false, 6},
+		{"put", "PUT", "/payments/1", "c", "", "100", http.StatusCreated, false, 7},
+		{"put again", "PUT", "/payments/1", "c", "", "100", http.StatusCreated, false, 8},
+		{"disabled", "POST", "/payments/1/export", "d", "", "100", http.StatusCreated, false, 9},
+		{"disabled again", "POST", "/payments/1/export", "d", "", "100", http.StatusCreated, false, 10},
+	}
+
+	var location string
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		if test.key != "" {
+			req.Header.Set("Idempotency-Key", test.key)
+		}
+		if test.addr != "" {
+			req.RemoteAddr = test.addr
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%s: Status was %d, should be %d", test.name, res.Code, test.status)
+		}
+		if replayed := res.Header().Get("Idempotent-Replayed") == "true"; replayed != test.replayed {
+			t.Errorf("%s: Replayed was %v, should be %v", test.name, replayed, test.replayed)
+		}
+		if n := atomic.LoadInt32(&calls); n != test.calls {
+			t.Errorf("%s: Handler was called %d times, should be %d", test.name, n, test.calls)
+		}
+		if test.name == "first" {
+			location = res.Header().Get("Location")
+		}
+		if test.replayed {
+			if test.name == "retry" && res.Header().Get("Location") != location {
+				t.Errorf("%s: Location was %q, should be %q", test.name, res.Header().Get("Location"), location)
+			}
+			if len(res.Header().Values("X-Request-ID")) != 1 {
+				t.Errorf("%s: X-Request-ID was %q, the first request ID should not be replayed", test.name, res.Header().Values("X-Request-ID"))
+			}
+			if res.Body.String() != test.body {
+				t.Errorf("%s: Body was %q, should be %q", test.name, res.Body.String(), test.body)
+			}
+		}
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := New()
+	mux.Route("/").Idempotent(NewIdempotency(time.Hour)).Post(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("body"))
+		req.Header.Set("Idempotency-Key", "a")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	done := make(chan struct{})
+	go func() {
+		serve()
+		close(done)
+	}()
+	<-started
+
+	res := serve()
+	if res.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Status was %d, should be %d", res.Code, http.StatusUnprocessableEntity)
+	}
+	if res.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After should be set")
+	}
+
+	close(release)
+	<-done
+	if res := serve(); res.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Response should be replayed once the first request finished")
+	}
+}
+
+func TestIdempotencyFailures(t *testing.T) {
+	var calls int32
+	mux := New()
+	mux.Config.Recover = true
+	mux.Config.PanicHandler = func(*http.Request, *Panic) {}
+	mux.Route("/").Idempotent(NewIdempotency(time.Hour)).Post(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			panic("oops")
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+
+	for i, status := range []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("body"))
+		req.Header.Set("Idempotency-Key", "a")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != status {
+			t.Errorf("Request %d: Status was %d, should be %d", i, res.Code, status)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("Handler was called %d times, failed requests should be retried", calls)
+	}
+}
+
+func TestMemoryIdempotencyStoreExpiry(t *testing.T) {
+	now := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	s := NewMemoryIdempotencyStore()
+	s.now = func() time.Time { return now }
+
+	if r, _ := s.Reserve("a", "x", time.Minute); r != nil {
+		t.Fatal("Key should be reserved")
+	}
+	s.Save("a", &IdempotencyRecord{Fingerprint: "x", Done: true, Status: 201}, time.Minute)
+	if r, _ := s.Reserve("a", "x", time.Minute); r == nil || r.Status != 201 {
+		t.Errorf("Record was %v, should be saved", r)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if r, _ := s.Reserve("a", "x", time.Minute); r != nil {
+		t.Errorf("Record was %v, should have expired", r)
+	}
+	if len(s.records) != 1 {
+		t.Errorf("Store had %d records, expired records should be removed", len(s.records))
+	}
+}
+
+func TestIdempotencyDefaults(t *testing.T) {
+	var calls int32
+	pay := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusCreated)
+	})
+	post := func(mux *Yam) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/payments", nil)
+		req.Header.Set("Idempotency-Key", "a")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	// Without a TTL or store responses are kept in the store of the
+	// Idempotency for DefaultIdempotencyTTL
+	mux := New()
+	mux.Route("/payments").Idempotent(&Idempotency{}).Post(pay)
+	post(mux)
+	if res := post(mux); res.Header().Get("Idempotent-Replayed") != "true" || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Retry was not replayed, handler called %d times", atomic.LoadInt32(&calls))
+	}
+
+	// Another mux does not share the records
+	other := New()
+	other.Route("/payments").Idempotent(&Idempotency{}).Post(pay)
+	if res := post(other); res.Header().Get("Idempotent-Replayed") != "" || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("Request to another mux was replayed, handler called %d times", atomic.LoadInt32(&calls))
+	}
+}
+
+func TestIdempotencyMaxBody(t *testing.T) {
+	pay := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	mux := New()
+	mux.Route("/payments").Idempotent(&Idempotency{MaxBody: 4}).Post(pay)
+	mux.Route("/refunds").Idempotent(&Idempotency{}).Post(pay)
+
+	var tests = []struct {
+		path   string
+		size   int
+		status int
+	}{
+		{"/payments", 4, http.StatusCreated},
+		{"/payments", 5, http.StatusRequestEntityTooLarge},
+		{"/refunds", DefaultIdempotencyMaxBody, http.StatusCreated},
+		{"/refunds", DefaultIdempotencyMaxBody + 1, http.StatusRequestEntityTooLarge},
+	}
+
+	for i, test := range tests {
+		req := httptest.NewRequest("POST", test.path, strings.NewReader(strings.Repeat("a", test.size)))
+		req.Header.Set("Idempotency-Key", strconv.Itoa(i))
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%s with %d bytes: Status was %d, should be %d", test.path, test.size, res.Code, test.status)
+		}
+	}
+}
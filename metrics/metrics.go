@@ -0,0 +1,271 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+/*
+Package metrics collects per route request metrics for YAM and exposes them
+in the Prometheus text exposition format, without any third party dependencies.
+
+	m := metrics.New()
+	mux := yam.New()
+	mux.Use(m.Middleware)
+	mux.Route("/users/:id").Get(users)
+	mux.Route("/metrics").Get(m.Handler())
+
+Requests are labelled by method and route pattern, for example "/users/:id",
+rather than by path. Requests that do not match a route handler are labelled
+with the status YAM replied with, "404" or "405", so unknown paths can not
+blow up the number of series.
+
+The following metrics are collected:
+
+	yam_http_requests_total{method,route,status}       counter
+	yam_http_request_duration_seconds{method,route}    histogram
+	yam_http_response_size_bytes{method,route}         histogram
+	yam_http_requests_in_flight{method,route}          gauge
+*/
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thisissoon/yam"
+)
+
+// Default histogram buckets
+var (
+	DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	DefaultSizeBuckets     = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+// Methods used as label values, anything else is labelled OTHER
+var methods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "OPTIONS": true, "TRACE": true, "CONNECT": true,
+}
+
+// Collects request metrics, configure the exported fields before serving
+// any requests
+type Metrics struct {
+	Namespace       string    // Prefix for metric names, defaults to "yam"
+	DurationBuckets []float64 // Upper bounds of the duration histogram buckets in seconds
+	SizeBuckets     []float64 // Upper bounds of the response size histogram buckets in bytes
+
+	mu        sync.Mutex
+	requests  map[requestLabels]uint64
+	durations map[routeLabels]*histogram
+	sizes     map[routeLabels]*histogram
+	inFlight  map[routeLabels]int64
+}
+
+type routeLabels struct {
+	method string
+	route  string
+}
+
+type requestLabels struct {
+	routeLabels
+	status int
+}
+
+// Constructs a new Metrics instance with the default buckets
+func New() *Metrics {
+	return &Metrics{
+		Namespace:       "yam",
+		DurationBuckets: DefaultDurationBuckets,
+		SizeBuckets:     DefaultSizeBuckets,
+		requests:        make(map[requestLabels]uint64),
+		durations:       make(map[routeLabels]*histogram),
+		sizes:           make(map[routeLabels]*histogram),
+		inFlight:        make(map[routeLabels]int64),
+	}
+}
+
+// Middleware recording metrics for each request, add it to the mux with
+// Yam.Use so it runs after routing
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.Method
+		if !methods[method] {
+			method = "OTHER"
+		}
+
+		// Requests without a route handler are replied to immediately by the
+		// mux so are not tracked as in flight
+		info := yam.CurrentRoute(r)
+		if info != nil {
+			labels := routeLabels{method, info.Pattern}
+			m.mu.Lock()
+			m.inFlight[labels]++
+			m.mu.Unlock()
+			defer func() {
+				m.mu.Lock()
+				m.inFlight[labels]--
+				m.mu.Unlock()
+			}()
+		}
+
+		start := time.Now()
+		rw := yam.WrapWriter(w)
+		next.ServeHTTP(rw, r)
+		m.observe(method, info, rw.Status(), rw.BytesWritten(), time.Since(start))
+	})
+}
+
+// Records a completed request
+func (m *Metrics) observe(method string, info *yam.RouteInfo, status int, size int64, d time.Duration) {
+	route := strconv.Itoa(status)
+	if info != nil {
+		route = info.Pattern
+	}
+	labels := routeLabels{method, route}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestLabels{labels, status}]++
+
+	if m.durations[labels] == nil {
+		m.durations[labels] = newHistogram(m.DurationBuckets)
+	}
+	m.durations[labels].observe(d.Seconds())
+
+	if m.sizes[labels] == nil {
+		m.sizes[labels] = newHistogram(m.SizeBuckets)
+	}
+	m.sizes[labels].observe(float64(size))
+}
+
+// Returns a handler serving the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+}
+
+// Writes the metrics in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	// Rendered under the lock and written after it is released, so a slow
+	// client does not hold up requests
+	var buf bytes.Buffer
+	m.render(&buf)
+
+	return buf.WriteTo(w)
+}
+
+// Renders the metrics in the Prometheus text format
+func (m *Metrics) render(buf *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := m.Namespace + "_http_"
+
+	fmt.Fprintf(buf, "# HELP %srequests_total Total number of HTTP requests.\n", name)
+	fmt.Fprintf(buf, "# TYPE %srequests_total counter\n", name)
+	requests := make([]requestLabels, 0, len(m.requests))
+	for labels := range m.requests {
+		requests = append(requests, labels)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].routeLabels != requests[j].routeLabels {
+			return requests[i].routeLabels.less(requests[j].routeLabels)
+		}
+		return requests[i].status < requests[j].status
+	})
+	for _, labels := range requests {
+		fmt.Fprintf(buf, "%srequests_total{%s,status=\"%d\"} %d\n", name, labels.routeLabels, labels.status, m.requests[labels])
+	}
+
+	writeHistograms(buf, name+"request_duration_seconds", "Duration of HTTP requests in seconds.", m.durations)
+	writeHistograms(buf, name+"response_size_bytes", "Size of HTTP response bodies in bytes.", m.sizes)
+
+	fmt.Fprintf(buf, "# HELP %srequests_in_flight Number of HTTP requests being served.\n", name)
+	fmt.Fprintf(buf, "# TYPE %srequests_in_flight gauge\n", name)
+	inFlight := make([]routeLabels, 0, len(m.inFlight))
+	for labels := range m.inFlight {
+		inFlight = append(inFlight, labels)
+	}
+	sort.Slice(inFlight, func(i, j int) bool { return inFlight[i].less(inFlight[j]) })
+	for _, labels := range inFlight {
+		fmt.Fprintf(buf, "%srequests_in_flight{%s} %d\n", name, labels, m.inFlight[labels])
+	}
+
+}
+
+func writeHistograms(w io.Writer, name, help string, histograms map[routeLabels]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+
+	keys := make([]routeLabels, 0, len(histograms))
+	for labels := range histograms {
+		keys = append(keys, labels)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+
+	for _, labels := range keys {
+		h := histograms[labels]
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+func (l routeLabels) less(o routeLabels) bool {
+	if l.route != o.route {
+		return l.route < o.route
+	}
+	return l.method < o.method
+}
+
+// Formats the labels for the exposition format
+func (l routeLabels) String() string {
+	return `method="` + escape(l.method) + `",route="` + escape(l.route) + `"`
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Escapes a label value
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// A cumulative histogram
+type histogram struct {
+	bounds []float64
+	counts []uint64 // Non cumulative count per bucket
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	bounds = append([]float64(nil), bounds...)
+	sort.Float64s(bounds)
+
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.count++
+	h.sum += v
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thisissoon/yam"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	mux := yam.New()
+	mux.Use(m.Middleware)
+	mux.Route("/users/:id").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	mux.Route("/metrics").Get(m.Handler())
+
+	var requests = []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/users/1"},
+		{"GET", "/users/2"},
+		{"POST", "/users/2"},
+		{"GET", "/random/1"},
+		{"GET", "/random/2"},
+		{"BREW", "/users/3"},
+	}
+	for _, req := range requests {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	body := res.Body.String()
+
+	if res.Header().Get("Content-Type") != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type was %v", res.Header().Get("Content-Type"))
+	}
+
+	expected := []string{
+		`yam_http_requests_total{method="GET",route="/users/:id",status="200"} 2`,
+		`yam_http_requests_total{method="POST",route="405",status="405"} 1`,
+		`yam_http_requests_total{method="GET",route="404",status="404"} 2`,
+		`yam_http_requests_total{method="OTHER",route="405",status="405"} 1`,
+		`yam_http_request_duration_seconds_count{method="GET",route="/users/:id"} 2`,
+		`yam_http_response_size_bytes_bucket{method="GET",route="/users/:id",le="100"} 2`,
+		`yam_http_response_size_bytes_sum{method="GET",route="/users/:id"} 10`,
+		`yam_http_requests_in_flight{method="GET",route="/users/:id"} 0`,
+		`yam_http_requests_in_flight{method="GET",route="/metrics"} 1`,
+		"# TYPE yam_http_request_duration_seconds histogram",
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Metrics should contain %s", line)
+		}
+	}
+
+	if strings.Contains(body, "/random") {
+		t.Error("Metrics should not be labelled with unmatched paths")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 0.1, 10})
+	for _, v := range []float64{0.05, 0.1, 0.5, 5, 50} {
+		h.observe(v)
+	}
+
+	expected := []uint64{2, 1, 1}
+	for i, count := range expected {
+		if h.counts[i] != count {
+			t.Errorf("Bucket %v count was %v, should be %v", h.bounds[i], h.counts[i], count)
+		}
+	}
+	if h.count != 5 {
+		t.Errorf("Count was %v, should be %v", h.count, 5)
+	}
+}
+
+// Blocks writes until released
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w blockingWriter) Write(b []byte) (int, error) {
+	<-w.release
+	return len(b), nil
+}
+
+func TestWriteToSlowClient(t *testing.T) {
+	m := New()
+	mux := yam.New()
+	mux.Use(m.Middleware)
+	mux.Route("/").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := blockingWriter{make(chan struct{})}
+	defer close(w.release)
+	go m.WriteTo(w)
+
+	served := make(chan struct{})
+	go func() {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(served)
+	}()
+
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Error("Request was held up by a slow metrics client")
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Media types a Problem can be rendered as, the order is the order of
+// preference when a client will accept any of them
+var problemTypes = []string{
+	"text/plain",
+	"application/problem+json",
+	"application/json",
+	"text/html",
+}
+
+// An RFC 7807 Problem Details object. Problem implements the error interface
+// so it can be returned from a HandlerFunc, and http.Handler so it can be
+// served directly. It is rendered as application/problem+json, HTML or plain
+// text depending on the Accept header of the request.
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Extension members, these are added to the top level of the JSON object
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// Constructs a new Problem for a HTTP status code, the title is set to the
+// standard status text
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Sets an extension member on the problem
+func (p *Problem) With(key string, value interface{}) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[key] = value
+
+	return p
+}
+
+// Implements the error interface
+func (p *Problem) Error() string {
+	s := strconv.Itoa(p.Status) + " " + p.Title
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+
+	return s
+}
+
+// Implements the json.Marshaler interface, extension members are merged into
+// the problem object
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	b, err := json.Marshal((*problem)(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return b, err
+	}
+
+	m := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(m)
+}
+
+// Implements the http.Handler interface. Writes the problem in the format
+// best matching the Accept header of the request.
+func (p *Problem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := p.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	id := RequestID(r)
+
+	var body []byte
+	contentType := negotiate(r.Header.Get("Accept"), problemTypes)
+	switch contentType {
+	case "application/problem+json", "application/json":
+		if _, ok := p.Extensions["request_id"]; id != "" && !ok {
+			// Copy so a shared Problem is not modified
+			c := *p
+			c.Extensions = map[string]interface{}{"request_id": id}
+			for k, v := range p.Extensions {
+				c.Extensions[k] = v
+			}
+			p = &c
+		}
+		body, _ = json.Marshal(p)
+		contentType = "application/problem+json"
+	case "text/html":
+		footer := ""
+		if id != "" {
+			footer = "<p>Request ID: <code>" + html.EscapeString(id) + "</code></p>\n"
+		}
+		body = []byte(fmt.Sprintf(
+			"<!DOCTYPE html>\n<html>\n<head><title>%d %s</title></head>\n<body>\n<h1>%d %s</h1>\n<p>%s</p>\n%s</body>\n</html>\n",
+			status, html.EscapeString(p.Title),
+			status, html.EscapeString(p.Title),
+			html.EscapeString(p.Detail), footer))
+	default:
+		body = []byte(p.Error() + "\n")
+		if id != "" {
+			body = append(body, "Request ID: "+id+"\n"...)
+		}
+		contentType = "text/plain"
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// A http.Handler that returns an error. Errors are passed to the error
+// handler of the mux serving the request.
+type HandlerFunc func(http.ResponseWriter, *http.Request) error
+
+// Implements the http.Handler interface
+func (f HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f(w, r); err != nil {
+		Error(w, r, err)
+	}
+}
+
+// Replies to the request with an error using the error handler of the mux
+// serving the request, falling back to DefaultErrorHandler when the request
+// was not served by YAM
+func Error(w http.ResponseWriter, r *http.Request, err error) {
+	if y, ok := r.Context().Value(yamKey).(*Yam); ok && y.Config.ErrorHandler != nil {
+		y.Config.ErrorHandler(w, r, err)
+		return
+	}
+
+	DefaultErrorHandler(w, r, err)
+}
+
+// Converts an error to a Problem. A *Problem anywhere in the error chain is
+// returned as is, an exceeded context deadline becomes a 504 Gateway Timeout,
+// a request body over its limit a 413 Content Too Large, and other errors
+// become a 500 Internal Server Error without exposing the error message to
+// the client.
+func ProblemFor(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return NewProblem(http.StatusGatewayTimeout, "")
+	}
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return NewProblem(http.StatusRequestEntityTooLarge, "the request body is over the limit of "+strconv.FormatInt(maxBytes.Limit, 10)+" bytes")
+	}
+
+	return NewProblem(http.StatusInternalServerError, "")
+}
+
+// Default error handler, renders the error as a Problem
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	ProblemFor(err).ServeHTTP(w, r)
+}
+
+// Picks the offer best matching an Accept style header. Each offer takes the
+// quality of the most specific range matching it, ties are broken by the order
+// of the offers. An empty header accepts anything.
+func negotiate(header string, offers []string) string {
+	if strings.TrimSpace(header) == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, part := range strings.Split(header, ",") {
+			value, quality := parseAccept(part)
+			s := -1
+			switch {
+			case value == offer:
+				s = 2
+			case strings.HasSuffix(value, "/*") && strings.HasPrefix(offer, value[:len(value)-1]):
+				s = 1
+			case value == "*/*" || value == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = quality, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+// Parses a single element of an Accept style header into its value and
+// quality
+func parseAccept(s string) (string, float64) {
+	params := strings.Split(s, ";")
+	value := strings.ToLower(strings.TrimSpace(params[0]))
+	q := 1.0
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				q = f
+			}
+		}
+	}
+
+	return value, q
+}
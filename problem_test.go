@@ -0,0 +1,128 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProblemNegotiation(t *testing.T) {
+	var tests = []struct {
+		accept      string
+		contentType string
+	}{
+		{"", "text/plain; charset=utf-8"},
+		{"*/*", "text/plain; charset=utf-8"},
+		{"application/json", "application/problem+json; charset=utf-8"},
+		{"application/problem+json", "application/problem+json; charset=utf-8"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8"},
+		{"text/plain;q=0, application/*", "application/problem+json; charset=utf-8"},
+		{"image/png", "text/plain; charset=utf-8"},
+	}
+
+	mux := New()
+	mux.Route("/foo")
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/bar", nil)
+		req.Header.Set("Accept", test.accept)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != http.StatusNotFound {
+			t.Errorf("Status was %v, should be %v", res.Code, http.StatusNotFound)
+		}
+		if res.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("Accept %q: Content-Type was %q, should be %q", test.accept, res.Header().Get("Content-Type"), test.contentType)
+		}
+	}
+}
+
+func TestProblemJSON(t *testing.T) {
+	p := NewProblem(http.StatusConflict, "already exists").With("id", "42")
+	p.Type = "https://example.com/problems/conflict"
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	res := httptest.NewRecorder()
+	p.ServeHTTP(res, req)
+
+	if res.Code != http.StatusConflict {
+		t.Errorf("Status was %v, should be %v", res.Code, http.StatusConflict)
+	}
+
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"type":   "https://example.com/problems/conflict",
+		"title":  "Conflict",
+		"status": float64(409),
+		"detail": "already exists",
+		"id":     "42",
+	}
+	for k, v := range expected {
+		if body[k] != v {
+			t.Errorf("%s was %v, should be %v", k, body[k], v)
+		}
+	}
+}
+
+func TestHandlerFuncError(t *testing.T) {
+	mux := New()
+	mux.Route("/problem").Get(HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return NewProblem(http.StatusPaymentRequired, "top up")
+	}))
+	mux.Route("/error").Get(HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return errors.New("database password is hunter2")
+	}))
+
+	var tests = []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/problem", http.StatusPaymentRequired, "402 Payment Required: top up\n"},
+		{"/error", http.StatusInternalServerError, "500 Internal Server Error\n"},
+	}
+
+	for _, test := range tests {
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest("GET", test.path, nil))
+
+		if res.Code != test.status {
+			t.Errorf("Status was %v, should be %v", res.Code, test.status)
+		}
+		if res.Body.String() != test.body {
+			t.Errorf("Body was %q, should be %q", res.Body.String(), test.body)
+		}
+	}
+}
+
+func TestCustomErrorHandler(t *testing.T) {
+	mux := New()
+	mux.Config.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		p := ProblemFor(err)
+		w.WriteHeader(p.Status)
+		w.Write([]byte(strings.ToUpper(p.Title)))
+	}
+	mux.Route("/foo").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("DELETE", "/foo", nil))
+
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Status was %v, should be %v", res.Code, http.StatusMethodNotAllowed)
+	}
+	if res.Body.String() != "METHOD NOT ALLOWED" {
+		t.Errorf("Body was %q, should be %q", res.Body.String(), "METHOD NOT ALLOWED")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits the rate of requests per client key. Attach it to a route with
+// Route.RateLimit, or use its Middleware anywhere middleware can be used.
+type RateLimiter struct {
+	Limit  int           // Requests allowed per window
+	Window time.Duration // Length of the window
+
+	// Returns the client key requests are counted against, KeyByIP by
+	// default. Requests with an empty key are not limited.
+	Key func(*http.Request) string
+
+	// Store counting requests, a MemoryRateLimitStore of the limiter by
+	// default. Requests are allowed if the store fails so an outage does not
+	// take down the API.
+	Store RateLimitStore
+
+	once         sync.Once
+	defaultStore *MemoryRateLimitStore
+}
+
+// Constructs a new RateLimiter allowing limit requests per window for each
+// client IP, counted in memory
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Limit:  limit,
+		Window: window,
+		Key:    KeyByIP,
+		Store:  NewMemoryRateLimitStore(),
+	}
+}
+
+// Stores request counts for rate limiting. Implementations backed by shared
+// storage allow limits to be enforced across instances.
+type RateLimitStore interface {
+	// Counts a request against the key, reporting whether it is within the
+	// limit of requests per window
+	Take(key string, limit int, window time.Duration) (RateLimitResult, error)
+}
+
+// The state of a rate limit after a request has been counted
+type RateLimitResult struct {
+	Allowed   bool          // The request is within the limit
+	Remaining int           // Requests remaining in the window
+	Reset     time.Duration // Time until the limit resets, or until a request will be allowed when not allowed
+}
+
+// Rate limits the handlers of the route and the routes under it. The routes
+// share the limit, which is separate from the limit of any other route the
+// limiter is attached to.
+//
+//	mux.Route("/login").RateLimit(yam.NewRateLimiter(5, time.Minute))
+func (r *Route) RateLimit(l *RateLimiter) *Route {
+	scope := r.Pattern() + " "
+
+	return r.Use(func(next http.Handler) http.Handler {
+		return l.middleware(next, scope)
+	})
+}
+
+// Middleware limiting the rate of requests, requests over the limit are
+// replied to with a 429 Too Many Requests through the error handler. The
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are set
+// on every response, and Retry-After when the limit is exceeded.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return l.middleware(next, "")
+}
+
+// Returns the store of the limiter, creating a MemoryRateLimitStore the first
+// time it is needed when none is set
+func (l *RateLimiter) store() RateLimitStore {
+	if l.Store != nil {
+		return l.Store
+	}
+	l.once.Do(func() {
+		l.defaultStore = NewMemoryRateLimitStore()
+	})
+
+	return l.defaultStore
+}
+
+// Middleware limiting the rate of requests, keys are prefixed with scope
+func (l *RateLimiter) middleware(next http.Handler, scope string) http.Handler {
+	keyFunc := l.Key
+	if keyFunc == nil {
+		keyFunc = KeyByIP
+	}
+	store := l.store()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := keyFunc(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		res, err := store.Take(scope+key, l.Limit, l.Window)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reset := strconv.Itoa(int(math.Ceil(res.Reset.Seconds())))
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(l.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", reset)
+
+		if !res.Allowed {
+			h.Set("Retry-After", reset)
+			Error(w, r, NewProblem(http.StatusTooManyRequests, ""))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Keys requests by the IP address of the client, see ClientIP
+func KeyByIP(r *http.Request) string {
+	return ClientIP(r)
+}
+
+// Returns a key function keying requests by the value of a header, for
+// example an API key
+func KeyByHeader(name string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// In memory RateLimitStore using a sliding window counter, which weights the
+// count of the previous window by how much of it overlaps the sliding window.
+// Keys are expired once they have not been used for two windows.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type rateWindow struct {
+	start    time.Time
+	length   time.Duration
+	current  int
+	previous int
+}
+
+// Constructs a new empty MemoryRateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		windows: make(map[string]*rateWindow),
+		now:     time.Now,
+	}
+}
+
+// Implements the RateLimitStore interface
+func (s *MemoryRateLimitStore) Take(key string, limit int, window time.Duration) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now, window)
+
+	w := s.windows[key]
+	if w == nil {
+		w = &rateWindow{start: now.Truncate(window), length: window}
+		s.windows[key] = w
+	}
+
+	// Move the window forward
+	if elapsed := now.Sub(w.start); elapsed >= window {
+		periods := elapsed / window
+		w.previous = w.current
+		if periods > 1 {
+			w.previous = 0
+		}
+		w.current = 0
+		w.start = w.start.Add(periods * window)
+	}
+
+	elapsed := now.Sub(w.start)
+	weight := 1 - float64(elapsed)/float64(window)
+	count := int(math.Floor(float64(w.previous)*weight)) + w.current
+
+	res := RateLimitResult{Reset: window - elapsed}
+	if count >= limit {
+		return res, nil
+	}
+
+	w.current++
+	res.Allowed = true
+	res.Remaining = limit - count - 1
+
+	return res, nil
+}
+
+// Removes keys that have not been used for two windows, at most once a window
+func (s *MemoryRateLimitStore) sweep(now time.Time, window time.Duration) {
+	if now.Sub(s.lastSweep) < window {
+		return
+	}
+	s.lastSweep = now
+
+	for key, w := range s.windows {
+		if now.Sub(w.start) >= 2*w.length {
+			delete(s.windows, key)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	limiter := NewRateLimiter(2, time.Minute)
+
+	mux := New()
+	mux.Route("/login").RateLimit(limiter).Post(fn)
+	mux.Route("/signup").RateLimit(limiter).Post(fn)
+
+	var tests = []struct {
+		path      string
+		ip        string
+		status    int
+		remaining string
+	}{
+		{"/login", "192.0.2.1:1234", http.StatusOK, "1"},
+		{"/login", "192.0.2.1:1234", http.StatusOK, "0"},
+		{"/login", "192.0.2.1:5678", http.StatusTooManyRequests, "0"},
+		{"/login", "192.0.2.2:1234", http.StatusOK, "1"},
+		{"/signup", "192.0.2.1:1234", http.StatusOK, "1"},
+	}
+
+	for i, test := range tests {
+		req := httptest.NewRequest("POST", test.path, nil)
+		req.RemoteAddr = test.ip
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%d: Status was %v, should be %v", i, res.Code, test.status)
+		}
+		if res.Header().Get("RateLimit-Limit") != "2" {
+			t.Errorf("%d: RateLimit-Limit was %q, should be %q", i, res.Header().Get("RateLimit-Limit"), "2")
+		}
+		if res.Header().Get("RateLimit-Remaining") != test.remaining {
+			t.Errorf("%d: RateLimit-Remaining was %q, should be %q", i, res.Header().Get("RateLimit-Remaining"), test.remaining)
+		}
+		retryAfter := res.Header().Get("Retry-After")
+		if (test.status == http.StatusTooManyRequests) != (retryAfter != "") {
+			t.Errorf("%d: Retry-After was %q", i, retryAfter)
+		}
+	}
+}
+
+func TestRateLimitGroupMiddleware(t *testing.T) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	limiter := NewRateLimiter(1, time.Minute)
+	limiter.Key = KeyByHeader("X-API-Key")
+
+	mux := New()
+	api := mux.Route("/api").Use(limiter.Middleware)
+	api.Route("/a").Get(fn)
+	api.Route("/b").Get(fn)
+
+	var tests = []struct {
+		path   string
+		key    string
+		status int
+	}{
+		{"/api/a", "one", http.StatusOK},
+		{"/api/b", "one", http.StatusTooManyRequests},
+		{"/api/b", "two", http.StatusOK},
+		{"/api/b", "", http.StatusOK},
+		{"/api/b", "", http.StatusOK},
+	}
+
+	for i, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.Header.Set("X-API-Key", test.key)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%d: Status was %v, should be %v", i, res.Code, test.status)
+		}
+	}
+}
+
+func TestRateLimitDefaults(t *testing.T) {
+	mux := New()
+	mux.Route("/").RateLimit(&RateLimiter{Limit: 1, Window: time.Minute}).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var codes []int
+	for i := 0; i < 2; i++ {
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+		codes = append(codes, res.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("Statuses were %v, requests should be counted in the default store", codes)
+	}
+
+	// Limiters without a store do not share counts, within a mux or across them
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux = New()
+	mux.Route("/a").Use((&RateLimiter{Limit: 1, Window: time.Minute}).Middleware).Get(fn)
+	mux.Route("/b").Use((&RateLimiter{Limit: 1, Window: time.Minute}).Middleware).Get(fn)
+	other := New()
+	other.Route("/a").Use((&RateLimiter{Limit: 1, Window: time.Minute}).Middleware).Get(fn)
+
+	var tests = []struct {
+		mux  *Yam
+		path string
+	}{
+		{mux, "/a"},
+		{mux, "/b"},
+		{other, "/a"},
+	}
+
+	for i, test := range tests {
+		res := httptest.NewRecorder()
+		test.mux.ServeHTTP(res, httptest.NewRequest("GET", test.path, nil))
+		if res.Code != http.StatusOK {
+			t.Errorf("%d %s: Status was %d, should be %d", i, test.path, res.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreSlidingWindow(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryRateLimitStore()
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		if res, _ := s.Take("k", 4, time.Minute); !res.Allowed {
+			t.Fatalf("Request %d should be allowed", i)
+		}
+	}
+	if res, _ := s.Take("k", 4, time.Minute); res.Allowed || res.Reset != time.Minute {
+		t.Errorf("Request should be denied until the window resets, was %+v", res)
+	}
+
+	// Half way through the next window half of the previous count remains
+	now = now.Add(90 * time.Second)
+	for i := 0; i < 2; i++ {
+		if res, _ := s.Take("k", 4, time.Minute); !res.Allowed {
+			t.Fatalf("Request %d should be allowed", i)
+		}
+	}
+	if res, _ := s.Take("k", 4, time.Minute); res.Allowed {
+		t.Error("Request should be denied by the weighted previous window")
+	}
+
+	// Unused keys are expired
+	now = now.Add(3 * time.Minute)
+	s.Take("other", 4, time.Minute)
+	if _, ok := s.windows["k"]; ok {
+		t.Error("Key should have been expired")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// A recovered panic from a route handler
+type Panic struct {
+	Value   interface{} // The value passed to panic
+	Stack   []byte      // Stack trace of the panicking goroutine
+	Pattern string      // Pattern of the matched route, empty when no route matched
+}
+
+// Implements the error interface
+func (p *Panic) Error() string {
+	return fmt.Sprintf("panic serving %s: %v", p.Pattern, p.Value)
+}
+
+// Default panic handler, logs the panic and stack trace with the standard logger
+func DefaultPanicHandler(r *http.Request, p *Panic) {
+	log.Printf("yam: panic serving %s %s (%s): %v\n%s", r.Method, r.URL.Path, p.Pattern, p.Value, p.Stack)
+}
+
+// Wraps a handler to recover panics, reporting them to the configured
+// PanicHandler and replying with a 500 Internal Server Error if nothing has
+// been written yet. http.ErrAbortHandler is re-panicked so net/http can abort
+// the response.
+func (y *Yam) recoverer(route *Route, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := WrapWriter(w)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			// Panics from other goroutines, such as a handler with a
+			// timeout, are re-panicked with their own stack
+			p := &Panic{Value: v, Stack: debug.Stack()}
+			if other, ok := v.(*Panic); ok {
+				c := *other
+				p = &c
+			}
+			if route != nil {
+				p.Pattern = route.Pattern()
+			}
+			if y.Config.PanicHandler != nil {
+				y.Config.PanicHandler(r, p)
+			}
+
+			if rw.HeaderWrittenAt().IsZero() {
+				Error(rw, r, NewProblem(http.StatusInternalServerError, ""))
+			}
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Reports a panic that can no longer be re-panicked on the serving goroutine
+// to the PanicHandler of the mux serving the request
+func reportPanic(r *http.Request, p *Panic) {
+	y, ok := r.Context().Value(yamKey).(*Yam)
+	if !ok || y.Config.PanicHandler == nil {
+		return
+	}
+	if info := CurrentRoute(r); info != nil {
+		p.Pattern = info.Pattern
+	}
+
+	y.Config.PanicHandler(r, p)
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	var recovered *Panic
+
+	mux := New()
+	mux.Config.Recover = true
+	mux.Config.PanicHandler = func(r *http.Request, p *Panic) {
+		recovered = p
+	}
+	mux.Route("/users/:id").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/users/42", nil))
+
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("Status was %v, should be %v", res.Code, http.StatusInternalServerError)
+	}
+	if recovered == nil {
+		t.Fatal("PanicHandler was not called")
+	}
+	if recovered.Value != "boom" {
+		t.Errorf("Value was %v, should be %v", recovered.Value, "boom")
+	}
+	if recovered.Pattern != "/users/:id" {
+		t.Errorf("Pattern was %v, should be %v", recovered.Pattern, "/users/:id")
+	}
+	if !strings.Contains(string(recovered.Stack), "recover_test.go") {
+		t.Error("Stack should contain the panicking handler")
+	}
+}
+
+func TestRecoverAfterWrite(t *testing.T) {
+	mux := New()
+	mux.Config.Recover = true
+	mux.Config.PanicHandler = func(r *http.Request, p *Panic) {}
+	mux.Route("/").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		panic("boom")
+	}))
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+
+	if res.Code != http.StatusAccepted {
+		t.Errorf("Status was %v, should be %v", res.Code, http.StatusAccepted)
+	}
+	if res.Body.String() != "partial" {
+		t.Errorf("Body was %q, should be %q", res.Body.String(), "partial")
+	}
+}
+
+func TestRecoverAbortHandler(t *testing.T) {
+	called := false
+
+	mux := New()
+	mux.Config.Recover = true
+	mux.Config.PanicHandler = func(r *http.Request, p *Panic) {
+		called = true
+	}
+	mux.Route("/").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("Recovered %v, should be %v", v, http.ErrAbortHandler)
+		}
+		if called {
+			t.Error("PanicHandler should not be called for http.ErrAbortHandler")
+		}
+	}()
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
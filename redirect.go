@@ -0,0 +1,125 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Sets whether Config.RedirectHTTPS and Config.CanonicalHost apply to the
+// route and the routes under it, unless they set their own. Health checks
+// usually hit the instance directly over HTTP and should not be redirected:
+//
+//	mux.Route("/health").Redirects(false).Get(health)
+func (r *Route) Redirects(enabled bool) *Route {
+	r.redirects = &enabled
+
+	return r
+}
+
+// Reports whether requests to the route may be redirected
+func (r *Route) effectiveRedirects() bool {
+	for route := r; route != nil; route = route.parent {
+		if route.redirects != nil {
+			return *route.redirects
+		}
+	}
+
+	return true
+}
+
+// Returns the URL to redirect the request to when it is not over HTTPS or not
+// for the canonical host, empty when it should be served. The path and query
+// are kept.
+func (y *Yam) redirect(r *http.Request, query string) string {
+	if r.Host == "" {
+		return ""
+	}
+
+	current := Scheme(r)
+	scheme, host := current, r.Host
+	if y.Config.RedirectHTTPS && scheme != "https" {
+		scheme = "https"
+		// The port of a plain HTTP listener is not the HTTPS port
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+			if strings.Contains(h, ":") {
+				host = "[" + h + "]"
+			}
+		}
+	}
+	if c := y.Config.CanonicalHost; c != "" && !strings.EqualFold(hostname(host), hostname(c)) {
+		host = c
+	}
+	if scheme == current && host == r.Host {
+		return ""
+	}
+
+	u := url.URL{Scheme: scheme, Host: host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: query}
+
+	return u.String()
+}
+
+// Returns a host without its port or IPv6 brackets
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+
+	return strings.Trim(host, "[]")
+}
+
+// Replies to the request with a redirect, GET and HEAD requests are given a
+// 301 Moved Permanently and other methods a 308 Permanent Redirect so the
+// method and body are kept
+func redirectHandler(url string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, url, status)
+	})
+}
+
+// Returns the scheme the client used for the request, http or https. When
+// the request came from one of Config.TrustedProxies it is read from the
+// Forwarded or X-Forwarded-Proto header, for the same hop as ClientIP so
+// values the client sent are ignored.
+func Scheme(r *http.Request) string {
+	if y, ok := r.Context().Value(yamKey).(*Yam); ok && y.fromTrustedProxy(r) {
+		if proto := y.forwardedProto(r.Header); proto != "" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// Returns the protocol the client used from the Forwarded or
+// X-Forwarded-Proto header of the request, empty if neither is set. It is
+// read from the hop of the client resolved like ClientIP, or the nearest hop
+// after it with a protocol, as the values before it come from the client.
+func (y *Yam) forwardedProto(h http.Header) string {
+	hops := forwarded(h)
+	client, _ := y.clientHop(hops)
+	if client == len(hops) {
+		// No hop has an address, the nearest was added by the trusted proxy
+		client--
+	}
+	for i := client; i >= 0 && i < len(hops); i++ {
+		if hops[i].proto != "" {
+			return hops[i].proto
+		}
+	}
+
+	return ""
+}
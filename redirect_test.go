@@ -0,0 +1,104 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestRedirects(t *testing.T) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mux := New()
+	mux.Config.RedirectHTTPS = true
+	mux.Config.CanonicalHost = "example.com"
+	mux.Config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	mux.Route("/users").Get(fn).Post(fn).Route("/:id").Get(fn)
+	mux.Route("/health").Redirects(false).Get(fn).Route("/ready").Get(fn)
+
+	var tests = []struct {
+		method   string
+		url      string
+		tls      bool
+		remote   string
+		header   map[string]string
+		status   int
+		location string
+	}{
+		{"GET", "http://example.com/users?page=2", false, "", nil, http.StatusMovedPermanently, "https://example.com/users?page=2"},
+		{"GET", "http://example.com/users/42?x=1", false, "", nil, http.StatusMovedPermanently, "https://example.com/users/42?x=1"},
+		{"GET", "http://www.example.com/users/a%2Fb", false, "", nil, http.StatusMovedPermanently, "https://example.com/users/a%2Fb"},
+		{"HEAD", "http://example.com/users", false, "", nil, http.StatusMovedPermanently, "https://example.com/users"},
+		{"POST", "http://example.com/users", false, "", nil, http.StatusPermanentRedirect, "https://example.com/users"},
+		{"GET", "http://example.com:8080/users", false, "", nil, http.StatusMovedPermanently, "https://example.com/users"},
+		{"GET", "https://example.com/users", true, "", nil, http.StatusOK, ""},
+		{"GET", "https://www.example.com/users?a=b", true, "", nil, http.StatusMovedPermanently, "https://example.com/users?a=b"},
+		{"DELETE", "https://www.example.com/users", true, "", nil, http.StatusPermanentRedirect, "https://example.com/users"},
+		{"GET", "http://www.example.com/nope", false, "", nil, http.StatusMovedPermanently, "https://example.com/nope"},
+		{"GET", "http://example.com/users", false, "10.0.0.1:1", map[string]string{"X-Forwarded-Proto": "https"}, http.StatusOK, ""},
+		{"GET", "http://example.com/users", false, "10.0.0.1:1", map[string]string{"Forwarded": "for=192.0.2.1;proto=https"}, http.StatusOK, ""},
+		{"GET", "http://example.com/users", false, "192.0.2.1:1", map[string]string{"X-Forwarded-Proto": "https"}, http.StatusMovedPermanently, "https://example.com/users"},
+		{"GET", "http://example.com/users", false, "10.0.0.1:1", map[string]string{"X-Forwarded-Proto": "https, http"}, http.StatusMovedPermanently, "https://example.com/users"},
+		{"GET", "http://example.com/users", false, "10.0.0.1:1", map[string]string{"X-Forwarded-For": "192.0.2.1", "X-Forwarded-Proto": "https, http"}, http.StatusMovedPermanently, "https://example.com/users"},
+		{"GET", "http://example.com/users", false, "10.0.0.1:1", map[string]string{"X-Forwarded-For": "192.0.2.1, 10.0.0.2", "X-Forwarded-Proto": "http, https"}, http.StatusMovedPermanently, "https://example.com/users"},
+		{"GET", "http://example.com/users", false, "10.0.0.1:1", map[string]string{"X-Forwarded-For": "192.0.2.1, 10.0.0.2", "X-Forwarded-Proto": "https"}, http.StatusOK, ""},
+		{"GET", "http://example.com/users", false, "10.0.0.1:1", map[string]string{"Forwarded": "for=198.51.100.1;proto=https, for=192.0.2.1;proto=http"}, http.StatusMovedPermanently, "https://example.com/users"},
+		{"GET", "http://example.com/users", false, "10.0.0.1:1", map[string]string{"Forwarded": "for=192.0.2.1;proto=https, for=10.0.0.2;proto=http"}, http.StatusOK, ""},
+		{"GET", "http://10.1.2.3/health", false, "", nil, http.StatusOK, ""},
+		{"GET", "http://10.1.2.3/health/ready", false, "", nil, http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		if !test.tls {
+			req.TLS = nil
+		} else {
+			req.TLS = &tls.ConnectionState{}
+		}
+		if test.remote != "" {
+			req.RemoteAddr = test.remote
+		}
+		for k, v := range test.header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s %s: Status was %d, should be %d", test.method, test.url, w.Code, test.status)
+		}
+		if l := w.Header().Get("Location"); l != test.location {
+			t.Errorf("%s %s: Location was %q, should be %q", test.method, test.url, l, test.location)
+		}
+	}
+}
+
+func TestRedirectHTTPSOnly(t *testing.T) {
+	mux := New()
+	mux.Config.RedirectHTTPS = true
+	mux.Route("/").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var tests = []struct {
+		url      string
+		location string
+	}{
+		{"http://www.example.com/", "https://www.example.com/"},
+		{"http://[::1]:8080/", "https://[::1]/"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.url, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if l := w.Header().Get("Location"); l != test.location {
+			t.Errorf("%s: Location was %q, should be %q", test.url, l, test.location)
+		}
+	}
+}
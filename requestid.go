@@ -0,0 +1,95 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Longest request ID accepted from a client
+const maxRequestIDLength = 128
+
+// Default request ID generator, returns 16 random bytes hex encoded, the
+// same format as a W3C Trace Context trace-id
+func DefaultRequestIDGenerator() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Returns the ID of the request, empty if request IDs are not enabled.
+// Middleware wrapping the mux can read it after the mux has served the
+// request when the request was prepared with WithRouteInfo.
+func RequestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey).(string); ok {
+		return id
+	}
+	if info, ok := r.Context().Value(routeKey).(*RouteInfo); ok {
+		return info.requestID
+	}
+
+	return ""
+}
+
+// Finds the ID of the request from the request ID header or the trace-id of
+// a traceparent header, generating one if neither is present. The ID is set
+// on the request header, so the default TRACE handler echoes it, on the
+// response header and on the request context.
+func (y *Yam) requestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	header := y.Config.RequestIDHeader
+	if header == "" {
+		header = "X-Request-ID"
+	}
+
+	id := r.Header.Get(header)
+	if !validRequestID(id) {
+		id = traceID(r.Header.Get("traceparent"))
+	}
+	if id == "" {
+		generate := y.Config.RequestIDGenerator
+		if generate == nil {
+			generate = DefaultRequestIDGenerator
+		}
+		id = generate()
+	}
+
+	r.Header.Set(header, id)
+	w.Header().Set(header, id)
+
+	return withValue(r, requestIDKey, id)
+}
+
+// Request IDs from clients end up in logs and response headers so only
+// printable ASCII without spaces is accepted
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the trace-id of a W3C traceparent header, empty if the header is
+// not valid: version "-" trace-id "-" parent-id "-" flags
+func traceID(traceparent string) string {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 {
+		return ""
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil || parts[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+
+	return strings.ToLower(parts[1])
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var id string
+
+	mux := New()
+	mux.Config.RequestID = true
+	mux.Config.RequestIDGenerator = func() string { return "generated" }
+	mux.Route("/").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = RequestID(r)
+	}))
+
+	var tests = []struct {
+		header   string
+		value    string
+		expected string
+	}{
+		{"", "", "generated"},
+		{"X-Request-ID", "abc-123", "abc-123"},
+		{"X-Request-ID", "bad id\r\nInjected: true", "generated"},
+		{"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"traceparent", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "generated"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if id != test.expected {
+			t.Errorf("RequestID was %q, should be %q", id, test.expected)
+		}
+		if res.Header().Get("X-Request-ID") != test.expected {
+			t.Errorf("Response header was %q, should be %q", res.Header().Get("X-Request-ID"), test.expected)
+		}
+	}
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	mux := New()
+	mux.Config.RequestID = true
+	mux.Config.RequestIDHeader = "X-Correlation-ID"
+	mux.Config.Trace = true
+	mux.Route("/")
+
+	req := httptest.NewRequest("TRACE", "/", nil)
+	req.Header.Set("X-Correlation-ID", "abc")
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	if res.Header().Get("X-Correlation-ID") != "abc" {
+		t.Errorf("Response header was %q, should be %q", res.Header().Get("X-Correlation-ID"), "abc")
+	}
+	if !strings.Contains(res.Body.String(), "X-Correlation-Id: abc\r\n") {
+		t.Errorf("TRACE body should contain the request ID, was %q", res.Body.String())
+	}
+}
+
+func TestRequestIDErrors(t *testing.T) {
+	mux := New()
+	mux.Config.RequestID = true
+	mux.Route("/foo").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/bar", nil)
+	req.Header.Set("X-Request-ID", "abc")
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	if res.Body.String() != "404 Not Found\nRequest ID: abc\n" {
+		t.Errorf("Body was %q", res.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/foo", nil)
+	req.Header.Set("X-Request-ID", "abc")
+	req.Header.Set("Accept", "application/json")
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+
+	body := map[string]interface{}{}
+	json.Unmarshal(res.Body.Bytes(), &body)
+	if body["request_id"] != "abc" || body["status"] != float64(405) {
+		t.Errorf("Body was %s", res.Body.String())
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mux := New()
+	mux.Config.Trace = true
+	mux.Config.SecurityHeaders = NewSecurityHeaders()
+	mux.Route("/page").Get(fn)
+	mux.Route("/embed").SecurityHeaders(&SecurityHeaders{FrameOptions: "SAMEORIGIN"}).Get(fn)
+	mux.Route("/raw").SecurityHeaders(nil).Route("/file").Get(fn)
+	mux.Route("/own").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	}))
+
+	var tests = []struct {
+		method string
+		path   string
+		status int
+		frame  string
+		hsts   string
+	}{
+		{"GET", "/page", http.StatusOK, "DENY", "max-age=63072000; includeSubDomains"},
+		{"GET", "/nope", http.StatusNotFound, "DENY", "max-age=63072000; includeSubDomains"},
+		{"DELETE", "/page", http.StatusMethodNotAllowed, "DENY", "max-age=63072000; includeSubDomains"},
+		{"OPTIONS", "/page", http.StatusOK, "DENY", "max-age=63072000; includeSubDomains"},
+		{"TRACE", "/page", http.StatusOK, "DENY", "max-age=63072000; includeSubDomains"},
+		{"GET", "/embed", http.StatusOK, "SAMEORIGIN", ""},
+		{"GET", "/raw/file", http.StatusOK, "", ""},
+		{"GET", "/own", http.StatusOK, "SAMEORIGIN", "max-age=63072000; includeSubDomains"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+
+		if w.Code != test.status {
+			t.Errorf("%s %s: Status was %d, should be %d", test.method, test.path, w.Code, test.status)
+		}
+		if v := w.Header().Get("X-Frame-Options"); v != test.frame {
+			t.Errorf("%s %s: X-Frame-Options was %q, should be %q", test.method, test.path, v, test.frame)
+		}
+		if v := w.Header().Get("Strict-Transport-Security"); v != test.hsts {
+			t.Errorf("%s %s: Strict-Transport-Security was %q, should be %q", test.method, test.path, v, test.hsts)
+		}
+	}
+}
+
+func TestSecurityHeadersNonce(t *testing.T) {
+	var nonce string
+
+	mux := New()
+	mux.Config.SecurityHeaders = &SecurityHeaders{
+		ContentSecurityPolicy: "script-src 'nonce-{nonce}'",
+		CSPReportOnly:         true,
+		PermissionsPolicy:     "camera=()",
+	}
+	mux.Route("/").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r)
+	}))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if nonce == "" {
+		t.Fatal("Nonce was empty")
+	}
+	if v := w.Header().Get("Content-Security-Policy-Report-Only"); v != "script-src 'nonce-"+nonce+"'" {
+		t.Errorf("Content-Security-Policy-Report-Only was %q", v)
+	}
+	if v := w.Header().Get("Content-Security-Policy"); v != "" {
+		t.Errorf("Content-Security-Policy was %q, should not be set", v)
+	}
+	if v := w.Header().Get("Permissions-Policy"); v != "camera=()" {
+		t.Errorf("Permissions-Policy was %q, should be %q", v, "camera=()")
+	}
+
+	first := nonce
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if nonce == first || strings.Contains(nonce, "{") {
+		t.Errorf("Nonce was %q, should be a new nonce", nonce)
+	}
+
+	// Without a nonce in the policy none is generated
+	mux.Config.SecurityHeaders = NewSecurityHeaders()
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if nonce != "" {
+		t.Errorf("Nonce was %q, should be empty", nonce)
+	}
+}
+
+func TestSecurityHeadersNonceShared(t *testing.T) {
+	var calls int32
+	mux := New()
+	mux.Config.SecurityHeaders = &SecurityHeaders{ContentSecurityPolicy: "script-src 'nonce-{nonce}'"}
+	mux.Route("/").Cache(time.Minute).Coalesce(true).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`<script nonce="` + CSPNonce(r) + `"></script>`))
+	}))
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		csp := w.Header().Get("Content-Security-Policy")
+		nonce := strings.TrimSuffix(strings.TrimPrefix(csp, "script-src 'nonce-"), "'")
+		if !strings.Contains(w.Body.String(), `nonce="`+nonce+`"`) {
+			t.Errorf("Body was %q, should use the nonce of %q", w.Body.String(), csp)
+		}
+	}
+	if calls != 3 || mux.Config.Cache.Len() != 0 {
+		t.Errorf("Handler was called %d times with %d cached responses, responses with a nonce should not be stored", calls, mux.Config.Cache.Len())
+	}
+}
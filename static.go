@@ -0,0 +1,305 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options for Route.Static
+type StaticOptions struct {
+	// File served for directories, and for unknown paths in SPA mode,
+	// index.html when empty
+	Index string
+
+	// List the files of directories without an index file, directories
+	// are not found otherwise
+	Browse bool
+
+	// Serve the index file for unknown paths without a file extension, so a
+	// single page application can route them
+	SPA bool
+
+	// Serve name.br and name.gz files in place of name when the client
+	// accepts the encoding
+	Precompressed bool
+
+	// Cache-Control max-age of files, files are revalidated on every request
+	// when zero. Files with a content hash in their name are cached for a
+	// year as immutable, index files are always revalidated.
+	MaxAge time.Duration
+
+	// Reports whether a file name contains a content hash, such as
+	// app.3f9a1c2b.js, by default a last dot or dash separated part of at
+	// least 8 letters and digits including a digit
+	Hashed func(name string) bool
+}
+
+// Serves files from fsys with GET and HEAD requests. The file path is the
+// value of the catch-all segment of the route, or the root of fsys for other
+// routes.
+//
+//	//go:embed dist
+//	var dist embed.FS
+//
+//	assets, _ := fs.Sub(dist, "dist")
+//	mux.Route("/*path").Static(assets, &yam.StaticOptions{SPA: true, Precompressed: true})
+//
+// Content types are detected from the file extension, and Range,
+// If-None-Match and If-Modified-Since requests are supported using a strong
+// ETag of each file. Dot files are not served. Options may be nil.
+func (r *Route) Static(fsys fs.FS, opts *StaticOptions) *Route {
+	if opts == nil {
+		opts = &StaticOptions{}
+	}
+	s := &static{fsys: fsys, opts: *opts, leaf: r.leaf}
+	if s.opts.Index == "" {
+		s.opts.Index = "index.html"
+	}
+	if s.opts.Hashed == nil {
+		s.opts.Hashed = hashedName
+	}
+
+	return r.Get(s)
+}
+
+type static struct {
+	fsys  fs.FS
+	opts  StaticOptions
+	leaf  string
+	etags sync.Map // File name to ETag of files without a modification time
+}
+
+// Implements the http.Handler interface
+func (s *static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rest string
+	if strings.HasPrefix(s.leaf, "*") {
+		rest = r.URL.Query().Get(s.leaf)
+	}
+	name := strings.TrimPrefix(path.Clean("/"+rest), "/")
+	if name == "" {
+		name = "."
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") && part != "." {
+			Error(w, r, NewProblem(http.StatusNotFound, ""))
+			return
+		}
+	}
+
+	info, err := fs.Stat(s.fsys, name)
+	switch {
+	case err != nil:
+		if s.opts.SPA && path.Ext(name) == "" {
+			s.serveFile(w, r, s.opts.Index)
+			return
+		}
+		Error(w, r, NewProblem(http.StatusNotFound, ""))
+	case info.IsDir():
+		if strings.HasPrefix(s.leaf, "*") && !strings.HasSuffix(r.URL.Path, "/") {
+			// Relative links in the directory resolve under it
+			u := url.URL{Path: r.URL.Path + "/", RawQuery: s.query(r)}
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+		index := path.Join(name, s.opts.Index)
+		if _, err := fs.Stat(s.fsys, index); err == nil {
+			s.serveFile(w, r, index)
+			return
+		}
+		if s.opts.Browse {
+			s.list(w, r, name)
+			return
+		}
+		Error(w, r, NewProblem(http.StatusNotFound, ""))
+	default:
+		s.serveFile(w, r, name)
+	}
+}
+
+// Returns the request query without the route values
+func (s *static) query(r *http.Request) string {
+	q := r.URL.Query()
+	if info := CurrentRoute(r); info != nil {
+		for k := range info.Params {
+			q.Del(k)
+		}
+	}
+
+	return q.Encode()
+}
+
+// Serves a file, or its precompressed version
+func (s *static) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	h := w.Header()
+	base := path.Base(name)
+	switch {
+	case base == s.opts.Index:
+		h.Set("Cache-Control", "no-cache")
+	case s.opts.Hashed(base):
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	case s.opts.MaxAge > 0:
+		h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(s.opts.MaxAge.Seconds())))
+	default:
+		h.Set("Cache-Control", "no-cache")
+	}
+
+	file := name
+	if s.opts.Precompressed {
+		h.Add("Vary", "Accept-Encoding")
+		if encoding, ext := s.precompressed(r, name); encoding != "" {
+			h.Set("Content-Encoding", encoding)
+			file = name + ext
+		}
+	}
+
+	f, err := s.fsys.Open(file)
+	if err != nil {
+		Error(w, r, NewProblem(http.StatusNotFound, ""))
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		Error(w, r, NewProblem(http.StatusNotFound, ""))
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		content = bytes.NewReader(b)
+	}
+
+	etag, err := s.etag(file, info, content)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+	h.Set("ETag", etag)
+
+	// The content type is detected from the name of the uncompressed file
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// Returns the encoding and file extension of a precompressed version of a
+// file the client accepts, empty if there is none
+func (s *static) precompressed(r *http.Request, name string) (string, string) {
+	header := r.Header.Get("Accept-Encoding")
+	if strings.TrimSpace(header) == "" {
+		return "", ""
+	}
+
+	for _, c := range []struct{ encoding, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+		if negotiate(header, []string{c.encoding}) == "" {
+			continue
+		}
+		if info, err := fs.Stat(s.fsys, name+c.ext); err == nil && !info.IsDir() {
+			return c.encoding, c.ext
+		}
+	}
+
+	return "", ""
+}
+
+// Returns the strong ETag of a file. Files with a modification time are
+// tagged by size and time, others, such as embedded files, by a hash of
+// their content which is computed once.
+func (s *static) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()), nil
+	}
+	if etag, ok := s.etags.Load(name); ok {
+		return etag.(string), nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	s.etags.Store(name, etag)
+
+	return etag, nil
+}
+
+// Writes an HTML listing of a directory
+func (s *static) list(w http.ResponseWriter, r *http.Request, name string) {
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var b strings.Builder
+	title := html.EscapeString(r.URL.Path)
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head><title>" + title + "</title></head>\n<body>\n<h1>" + title + "</h1>\n<ul>\n")
+	for _, e := range entries {
+		entry := e.Name()
+		if strings.HasPrefix(entry, ".") {
+			continue
+		}
+		if e.IsDir() {
+			entry += "/"
+		}
+		u := url.URL{Path: entry}
+		b.WriteString(`<li><a href="` + html.EscapeString(u.String()) + `">` + html.EscapeString(entry) + "</a></li>\n")
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(b.String()))
+}
+
+// Reports whether the last dot or dash separated part of a file name, before
+// its extension, looks like a content hash
+func hashedName(name string) bool {
+	name = strings.TrimSuffix(name, path.Ext(name))
+	i := strings.LastIndexAny(name, ".-")
+	if i < 0 {
+		return false
+	}
+	part := name[i+1:]
+	if len(part) < 8 {
+		return false
+	}
+
+	digit := false
+	for _, c := range part {
+		switch {
+		case c >= '0' && c <= '9':
+			digit = true
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		default:
+			return false
+		}
+	}
+
+	return digit
+}
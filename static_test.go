@@ -0,0 +1,178 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var staticFS = fstest.MapFS{
+	"index.html":             {Data: []byte("<h1>Home</h1>")},
+	"app.3f9a1c2b.js":        {Data: []byte("console.log('app')")},
+	"app.3f9a1c2b.js.br":     {Data: []byte("br")},
+	"app.3f9a1c2b.js.gz":     {Data: []byte("gz")},
+	"style.css":              {Data: []byte("body{}"), ModTime: time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)},
+	"docs/guide.txt":         {Data: []byte("0123456789")},
+	"docs/images/logo.svg":   {Data: []byte("<svg/>")},
+	"blog/index.html":        {Data: []byte("<h1>Blog</h1>")},
+	".env":                   {Data: []byte("SECRET=1")},
+	"docs/.hidden/notes.txt": {Data: []byte("hidden")},
+}
+
+func TestStatic(t *testing.T) {
+	mux := New()
+	mux.Route("/api/users").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("users"))
+	}))
+	mux.Route("/assets/*path").Static(staticFS, &StaticOptions{Browse: true, MaxAge: time.Hour, Precompressed: true})
+	mux.Route("/*path").Static(staticFS, &StaticOptions{SPA: true})
+
+	var tests = []struct {
+		path         string
+		header       []string
+		status       int
+		body         string
+		contentType  string
+		cacheControl string
+		encoding     string
+	}{
+		{"/", nil, http.StatusOK, "<h1>Home</h1>", "text/html; charset=utf-8", "no-cache", ""},
+		{"/index.html", nil, http.StatusOK, "<h1>Home</h1>", "text/html; charset=utf-8", "no-cache", ""},
+		{"/style.css", nil, http.StatusOK, "body{}", "text/css; charset=utf-8", "no-cache", ""},
+		{"/app.3f9a1c2b.js", nil, http.StatusOK, "console.log('app')", "text/javascript; charset=utf-8", "public, max-age=31536000, immutable", ""},
+		{"/api/users", nil, http.StatusOK, "users", "", "", ""},
+		{"/settings/profile", nil, http.StatusOK, "<h1>Home</h1>", "text/html; charset=utf-8", "no-cache", ""},
+		{"/missing.js", nil, http.StatusNotFound, "", "", "", ""},
+		{"/.env", nil, http.StatusNotFound, "", "", "", ""},
+		{"/../.env", nil, http.StatusNotFound, "", "", "", ""},
+		{"/docs/.hidden/notes.txt", nil, http.StatusNotFound, "", "", "", ""},
+		{"/blog", nil, http.StatusMovedPermanently, "", "", "", ""},
+		{"/blog/", nil, http.StatusOK, "<h1>Blog</h1>", "text/html; charset=utf-8", "no-cache", ""},
+		{"/docs/", nil, http.StatusNotFound, "", "", "", ""},
+		{"/docs/guide.txt", []string{"Range", "bytes=2-4"}, http.StatusPartialContent, "234", "text/plain; charset=utf-8", "no-cache", ""},
+		{"/assets/docs/guide.txt", nil, http.StatusOK, "0123456789", "text/plain; charset=utf-8", "public, max-age=3600", ""},
+		{"/assets/app.3f9a1c2b.js", []string{"Accept-Encoding", "gzip, br"}, http.StatusOK, "br", "text/javascript; charset=utf-8", "public, max-age=31536000, immutable", "br"},
+		{"/assets/app.3f9a1c2b.js", []string{"Accept-Encoding", "gzip"}, http.StatusOK, "gz", "text/javascript; charset=utf-8", "public, max-age=31536000, immutable", "gzip"},
+		{"/assets/app.3f9a1c2b.js", []string{"Accept-Encoding", "br;q=0, deflate"}, http.StatusOK, "console.log('app')", "text/javascript; charset=utf-8", "public, max-age=31536000, immutable", ""},
+		{"/assets/missing", nil, http.StatusNotFound, "", "", "", ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		for i := 0; i < len(test.header); i += 2 {
+			req.Header.Set(test.header[i], test.header[i+1])
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		if res.Code != test.status {
+			t.Errorf("%s: Status was %d, should be %d", test.path, res.Code, test.status)
+			continue
+		}
+		if test.body != "" && res.Body.String() != test.body {
+			t.Errorf("%s: Body was %q, should be %q", test.path, res.Body.String(), test.body)
+		}
+		if test.contentType != "" && res.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("%s: Content-Type was %q, should be %q", test.path, res.Header().Get("Content-Type"), test.contentType)
+		}
+		if test.cacheControl != "" && res.Header().Get("Cache-Control") != test.cacheControl {
+			t.Errorf("%s: Cache-Control was %q, should be %q", test.path, res.Header().Get("Cache-Control"), test.cacheControl)
+		}
+		if res.Header().Get("Content-Encoding") != test.encoding {
+			t.Errorf("%s: Content-Encoding was %q, should be %q", test.path, res.Header().Get("Content-Encoding"), test.encoding)
+		}
+	}
+}
+
+func TestStaticRedirect(t *testing.T) {
+	mux := New()
+	mux.Route("/*path").Static(staticFS, nil)
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/blog?page=2", nil))
+
+	if res.Header().Get("Location") != "/blog/?page=2" {
+		t.Errorf("Location was %q, should be %q", res.Header().Get("Location"), "/blog/?page=2")
+	}
+}
+
+func TestStaticConditional(t *testing.T) {
+	mux := New()
+	mux.Route("/*path").Static(staticFS, nil)
+
+	for _, path := range []string{"/app.3f9a1c2b.js", "/style.css"} {
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+		etag := res.Header().Get("ETag")
+		if !strings.HasPrefix(etag, `"`) {
+			t.Errorf("%s: ETag was %q, should be strong", path, etag)
+		}
+
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("If-None-Match", etag)
+		res = httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != http.StatusNotModified {
+			t.Errorf("%s: Status was %d, should be %d", path, res.Code, http.StatusNotModified)
+		}
+
+		req = httptest.NewRequest("HEAD", path, nil)
+		res = httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != http.StatusOK || res.Body.Len() != 0 || res.Header().Get("ETag") != etag {
+			t.Errorf("%s: HEAD was %d with ETag %q", path, res.Code, res.Header().Get("ETag"))
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/style.css", nil)
+	req.Header.Set("If-Modified-Since", time.Date(2015, 10, 22, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusNotModified {
+		t.Errorf("Status was %d, should be %d", res.Code, http.StatusNotModified)
+	}
+}
+
+func TestStaticBrowse(t *testing.T) {
+	mux := New()
+	mux.Route("/files/*path").Static(staticFS, &StaticOptions{Browse: true})
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/files/docs/", nil))
+
+	body := res.Body.String()
+	if !strings.Contains(body, `<a href="guide.txt">guide.txt</a>`) || !strings.Contains(body, `<a href="images/">images/</a>`) {
+		t.Errorf("Listing was %q", body)
+	}
+	if strings.Contains(body, ".hidden") {
+		t.Errorf("Listing was %q, dot files should not be listed", body)
+	}
+}
+
+func TestHashedName(t *testing.T) {
+	var tests = []struct {
+		name   string
+		hashed bool
+	}{
+		{"app.3f9a1c2b.js", true},
+		{"index-BXa9cG3f.js", true},
+		{"chunk.a1b2c3d4e5f6.css", true},
+		{"my-component.js", false},
+		{"app.js", false},
+		{"vendor-2015.js", false},
+		{"logo.svg", false},
+	}
+
+	for _, test := range tests {
+		if hashedName(test.name) != test.hashed {
+			t.Errorf("%s: Hashed was %v, should be %v", test.name, !test.hashed, test.hashed)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Sets the maximum duration handlers of the route, and of the routes under it
+// unless they set their own, have to respond. The request context carries the
+// deadline, and if the handler overruns a 503 Service Unavailable is replied
+// through the error handler instead of its response.
+//
+// The response is buffered until the handler returns so handlers can not
+// stream, a zero duration disables the timeout for streaming routes:
+//
+//	mux.Root.Timeout(time.Second)                       // Default for every route
+//	mux.Route("/search").Timeout(200 * time.Millisecond) // Fast endpoint
+//	mux.Route("/events").Timeout(0).Get(stream)         // Streaming, no timeout
+func (r *Route) Timeout(d time.Duration) *Route {
+	r.timeout = &d
+
+	return r
+}
+
+// Returns the timeout for the route, set on the route or inherited from the
+// routes above it, falling back to Config.Timeout
+func (r *Route) effectiveTimeout() time.Duration {
+	for route := r; route != nil; route = route.parent {
+		if route.timeout != nil {
+			return *route.timeout
+		}
+	}
+
+	return r.yam.Config.Timeout
+}
+
+// Runs the handler with a deadline, buffering its response so a timeout
+// error can be written instead if it overruns
+func timeoutHandler(next http.Handler, d time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				// The stack is only available on this goroutine
+				var p interface{} = v
+				if v != http.ErrAbortHandler {
+					p = &Panic{Value: v, Stack: debug.Stack()}
+				}
+
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				if tw.timedOut {
+					// Nothing is waiting for the handler any more
+					if p, ok := p.(*Panic); ok {
+						reportPanic(r, p)
+					}
+					return
+				}
+				panicked <- p
+			}()
+			next.ServeHTTP(tw, r)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			// Re-panic on the serving goroutine so it can be recovered
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			dst := w.Header()
+			for k, v := range tw.header {
+				dst[k] = v
+			}
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			tw.timedOut = true
+			tw.mu.Unlock()
+			// The handler may have panicked as the deadline passed
+			select {
+			case p := <-panicked:
+				panic(p)
+			default:
+			}
+			if ctx.Err() == context.DeadlineExceeded {
+				Error(w, r, NewProblem(http.StatusServiceUnavailable, "the request timed out"))
+			}
+		}
+	})
+}
+
+// Buffers the response of a handler with a timeout
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut || w.status != 0 || (code >= 100 && code < 200) {
+		return
+	}
+	w.status = code
+}
@@ -0,0 +1,140 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	sleep := func(d time.Duration) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(d):
+				w.Header().Set("X-Slept", d.String())
+				w.WriteHeader(http.StatusAccepted)
+				w.Write([]byte("done"))
+			case <-r.Context().Done():
+			}
+		})
+	}
+
+	mux := New()
+	mux.Config.Timeout = time.Hour
+	api := mux.Route("/api").Timeout(20 * time.Millisecond)
+	api.Route("/slow").Get(sleep(time.Second))
+	api.Route("/fast").Get(sleep(0))
+	api.Route("/stream").Timeout(0).Get(sleep(50 * time.Millisecond))
+	mux.Route("/other").Get(sleep(50 * time.Millisecond))
+
+	var tests = []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/api/slow", http.StatusServiceUnavailable, "503 Service Unavailable: the request timed out\n"},
+		{"/api/fast", http.StatusAccepted, "done"},
+		{"/api/stream", http.StatusAccepted, "done"},
+		{"/other", http.StatusAccepted, "done"},
+	}
+
+	for _, test := range tests {
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest("GET", test.path, nil))
+
+		if res.Code != test.status {
+			t.Errorf("%s: Status was %v, should be %v", test.path, res.Code, test.status)
+		}
+		if res.Body.String() != test.body {
+			t.Errorf("%s: Body was %q, should be %q", test.path, res.Body.String(), test.body)
+		}
+	}
+}
+
+func TestTimeoutDeadline(t *testing.T) {
+	var deadline time.Time
+
+	mux := New()
+	mux.Route("/").Timeout(time.Minute).Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+		w.Header().Set("X-Foo", "bar")
+	}))
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+
+	if d := time.Until(deadline); d <= 0 || d > time.Minute {
+		t.Errorf("Deadline should be within a minute, was %v", d)
+	}
+	if res.Header().Get("X-Foo") != "bar" {
+		t.Error("Buffered headers should be copied to the response")
+	}
+}
+
+func TestDeadlineExceededError(t *testing.T) {
+	mux := New()
+	mux.Route("/").Get(HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return context.DeadlineExceeded
+	}))
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+
+	if res.Code != http.StatusGatewayTimeout {
+		t.Errorf("Status was %v, should be %v", res.Code, http.StatusGatewayTimeout)
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	panics := make(chan *Panic, 2)
+
+	mux := New()
+	mux.Config.Recover = true
+	mux.Config.PanicHandler = func(r *http.Request, p *Panic) {
+		panics <- p
+	}
+	api := mux.Route("/api").Timeout(20 * time.Millisecond)
+	api.Route("/now").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panicNow()
+	}))
+	api.Route("/late").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		panic("late")
+	}))
+
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/api/now", nil))
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("Status was %v, should be %v", res.Code, http.StatusInternalServerError)
+	}
+	p := <-panics
+	if p.Value != "now" || p.Pattern != "/api/now" || !strings.Contains(string(p.Stack), "panicNow") {
+		t.Errorf("Panic was %v in %s, should have the stack of the handler:\n%s", p.Value, p.Pattern, p.Stack)
+	}
+
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/api/late", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status was %v, should be %v", res.Code, http.StatusServiceUnavailable)
+	}
+	select {
+	case p := <-panics:
+		if p.Value != "late" || p.Pattern != "/api/late" {
+			t.Errorf("Panic was %v in %s, should be %v in %s", p.Value, p.Pattern, "late", "/api/late")
+		}
+	case <-time.After(time.Second):
+		t.Error("Panic after the timeout was not reported")
+	}
+}
+
+func panicNow() {
+	panic("now")
+}
@@ -0,0 +1,156 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Identifies a trace
+type TraceID [16]byte
+
+// Returns the lower case hex encoding of the ID
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Reports whether the ID is all zeros, which is invalid
+func (id TraceID) IsZero() bool {
+	return id == TraceID{}
+}
+
+// Identifies a span within a trace
+type SpanID [8]byte
+
+// Returns the lower case hex encoding of the ID
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Reports whether the ID is all zeros, which is invalid
+func (id SpanID) IsZero() bool {
+	return id == SpanID{}
+}
+
+// Sampled flag of the trace-flags field
+const FlagSampled byte = 0x01
+
+// The W3C Trace Context propagated between services, as carried by the
+// traceparent and tracestate headers
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	State   string // Vendor specific tracestate, propagated as is
+}
+
+// Reports whether the context has a valid trace and span ID
+func (sc SpanContext) IsValid() bool {
+	return !sc.TraceID.IsZero() && !sc.SpanID.IsZero()
+}
+
+// Reports whether the trace is sampled
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Returns the traceparent header value for the context
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// Parses traceparent and tracestate header values. Future versions of the
+// traceparent format are accepted as long as they start with the version 00
+// fields.
+func Parse(traceparent, tracestate string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return sc, false
+	}
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Flags = flags[0]
+	sc.State = strings.TrimSpace(tracestate)
+
+	return sc, sc.IsValid()
+}
+
+// Decodes lower case hex into dst
+func decodeHex(dst []byte, s string) bool {
+	if strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Reads the span context from the traceparent and tracestate headers
+func Extract(h http.Header) (SpanContext, bool) {
+	return Parse(h.Get("traceparent"), strings.Join(h.Values("tracestate"), ","))
+}
+
+// Sets the traceparent and tracestate headers for the span in the context,
+// use it to propagate the trace to outgoing requests:
+//
+//	req, _ := http.NewRequestWithContext(r.Context(), "GET", "http://users/1", nil)
+//	tracing.Inject(r.Context(), req.Header)
+func Inject(ctx context.Context, h http.Header) {
+	span := FromContext(ctx)
+	if span == nil {
+		return
+	}
+
+	h.Set("traceparent", span.Context.Traceparent())
+	if span.Context.State != "" {
+		h.Set("tracestate", span.Context.State)
+	} else {
+		h.Del("tracestate")
+	}
+}
+
+type contextKey struct{}
+
+// Returns the current span of the context, nil if there is none
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(contextKey{}).(*Span)
+	return span
+}
+
+// Returns a copy of the context with the span as its current span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, contextKey{}, span)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id.IsZero() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id.IsZero() {
+		rand.Read(id[:])
+	}
+	return id
+}
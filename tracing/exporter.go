@@ -0,0 +1,92 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Exporter keeping finished spans in memory, useful in tests
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// Implements the Exporter interface
+func (e *MemoryExporter) Export(s *Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, s)
+	e.mu.Unlock()
+}
+
+// Returns the exported spans in the order they finished
+func (e *MemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]*Span(nil), e.spans...)
+}
+
+// Removes all exported spans
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+// Exporter writing spans as JSON, one per line
+type StdoutExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Constructs a new exporter writing to w, typically os.Stdout
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{w: w}
+}
+
+// Implements the Exporter interface
+func (e *StdoutExporter) Export(s *Span) {
+	s.mu.Lock()
+	span := struct {
+		Name       string                 `json:"name"`
+		Kind       string                 `json:"kind"`
+		TraceID    string                 `json:"trace_id"`
+		SpanID     string                 `json:"span_id"`
+		ParentID   string                 `json:"parent_span_id,omitempty"`
+		TraceState string                 `json:"trace_state,omitempty"`
+		Start      time.Time              `json:"start"`
+		End        time.Time              `json:"end"`
+		Duration   float64                `json:"duration"`
+		Attributes map[string]interface{} `json:"attributes,omitempty"`
+		Error      bool                   `json:"error,omitempty"`
+	}{
+		Name:       s.Name,
+		Kind:       s.Kind,
+		TraceID:    s.Context.TraceID.String(),
+		SpanID:     s.Context.SpanID.String(),
+		TraceState: s.Context.State,
+		Start:      s.Start,
+		End:        s.End,
+		Duration:   s.End.Sub(s.Start).Seconds(),
+		Attributes: s.Attributes,
+		Error:      s.Error,
+	}
+	if !s.Parent.IsZero() {
+		span.ParentID = s.Parent.String()
+	}
+	b, err := json.Marshal(span)
+	s.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	e.w.Write(append(b, '\n'))
+	e.mu.Unlock()
+}
@@ -0,0 +1,177 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+/*
+Package tracing provides W3C Trace Context distributed tracing for YAM.
+
+Each request served by the mux becomes a server span named after the method
+and matched route pattern, for example "GET /users/:id". The incoming
+traceparent and tracestate headers are honoured so the span joins the
+caller's trace, and Inject propagates the trace to outgoing requests.
+
+	tracer := tracing.New(tracing.NewStdoutExporter(os.Stdout))
+	mux := yam.New()
+	mux.Use(tracer.Middleware)
+
+Finished spans are handed to an Exporter. A MemoryExporter for tests and a
+StdoutExporter writing JSON lines are provided, so tracing works without a
+collector.
+*/
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thisissoon/yam"
+)
+
+// Kinds of span
+const (
+	KindServer   = "server"
+	KindInternal = "internal"
+)
+
+// A timed operation within a trace
+type Span struct {
+	Name       string
+	Kind       string
+	Context    SpanContext
+	Parent     SpanID // Zero for a root span
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Error      bool // The operation failed
+
+	mu     sync.Mutex
+	tracer *Tracer
+	ended  bool
+}
+
+// Sets an attribute on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	s.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// Marks the span as failed
+func (s *Span) SetError() {
+	s.mu.Lock()
+	s.Error = true
+	s.mu.Unlock()
+}
+
+// Ends the span and exports it if the trace is sampled, calling Finish more
+// than once has no effect
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled() && s.tracer.Exporter != nil {
+		s.tracer.Exporter.Export(s)
+	}
+}
+
+// Receives finished spans
+type Exporter interface {
+	Export(*Span)
+}
+
+// Creates spans and hands them to an Exporter once finished
+type Tracer struct {
+	Exporter Exporter
+}
+
+// Constructs a new Tracer exporting to the exporter
+func New(e Exporter) *Tracer {
+	return &Tracer{Exporter: e}
+}
+
+// Starts a span as a child of the current span of the context, or as the
+// root of a new trace. The span must be finished with Finish.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	var parent SpanContext
+	if span := FromContext(ctx); span != nil {
+		parent = span.Context
+	}
+	span := t.start(name, KindInternal, parent)
+
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) start(name, kind string, parent SpanContext) *Span {
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+		tracer:     t,
+	}
+
+	if parent.IsValid() {
+		span.Context = SpanContext{TraceID: parent.TraceID, Flags: parent.Flags, State: parent.State}
+		span.Parent = parent.SpanID
+	} else {
+		span.Context = SpanContext{TraceID: newTraceID(), Flags: FlagSampled}
+	}
+	span.Context.SpanID = newSpanID()
+
+	return span
+}
+
+// Middleware creating a server span for each request, add it to the mux
+// with Yam.Use so the span can be named after the matched route
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, _ := Extract(r.Header)
+		if p := FromContext(r.Context()); p != nil {
+			parent = p.Context
+		}
+
+		name := r.Method
+		info := yam.CurrentRoute(r)
+		if info != nil {
+			name += " " + info.Pattern
+		}
+
+		span := t.start(name, KindServer, parent)
+		defer span.Finish()
+
+		span.Attributes["http.request.method"] = r.Method
+		span.Attributes["url.path"] = r.URL.Path
+		span.Attributes["client.address"] = yam.ClientIP(r)
+		if ua := r.UserAgent(); ua != "" {
+			span.Attributes["user_agent.original"] = ua
+		}
+		if id := yam.RequestID(r); id != "" {
+			span.Attributes["yam.request_id"] = id
+		}
+		if info != nil {
+			span.Attributes["http.route"] = info.Pattern
+			for k, v := range info.Params {
+				span.Attributes["yam.param."+strings.TrimPrefix(k, ":")] = strings.Join(v, ",")
+			}
+		}
+
+		rw := yam.WrapWriter(w)
+		next.ServeHTTP(rw, r.WithContext(ContextWithSpan(r.Context(), span)))
+
+		status := rw.Status()
+		span.SetAttribute("http.response.status_code", status)
+		span.SetAttribute("http.response.body.size", rw.BytesWritten())
+		if status >= 500 {
+			span.SetError()
+		}
+	})
+}
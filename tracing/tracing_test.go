@@ -0,0 +1,131 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thisissoon/yam"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		traceparent string
+		valid       bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		sc, ok := Parse(test.traceparent, "")
+		if ok != test.valid {
+			t.Errorf("%q valid was %v, should be %v", test.traceparent, ok, test.valid)
+		}
+		if ok && test.traceparent[:2] == "00" && sc.Traceparent() != test.traceparent {
+			t.Errorf("Traceparent was %q, should be %q", sc.Traceparent(), test.traceparent)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := &MemoryExporter{}
+	tracer := New(exporter)
+
+	var outgoing http.Header
+	mux := yam.New()
+	mux.Use(tracer.Middleware)
+	mux.Route("/users/:id").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, child := tracer.Start(r.Context(), "load user")
+		child.Finish()
+
+		outgoing = http.Header{}
+		Inject(ctx, outgoing)
+	}))
+
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=value")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nope", nil))
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("Exported %v spans, should be %v", len(spans), 3)
+	}
+
+	child, server, unmatched := spans[0], spans[1], spans[2]
+	if server.Name != "GET /users/:id" || server.Kind != KindServer {
+		t.Errorf("Span was %q %q, should be %q %q", server.Kind, server.Name, KindServer, "GET /users/:id")
+	}
+	if server.Context.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("Span should continue the incoming trace, was %s parent %s", server.Context.TraceID, server.Parent)
+	}
+	if server.Attributes["http.route"] != "/users/:id" || server.Attributes["yam.param.id"] != "42" {
+		t.Errorf("Attributes were %v", server.Attributes)
+	}
+	if server.Attributes["http.response.status_code"] != 200 {
+		t.Errorf("Status attribute was %v, should be %v", server.Attributes["http.response.status_code"], 200)
+	}
+	if child.Parent != server.Context.SpanID || child.Context.TraceID != server.Context.TraceID {
+		t.Error("Child span should be a child of the server span")
+	}
+	if outgoing.Get("traceparent") != child.Context.Traceparent() || outgoing.Get("tracestate") != "vendor=value" {
+		t.Errorf("Outgoing headers were %v", outgoing)
+	}
+
+	if unmatched.Name != "GET" || unmatched.Attributes["http.response.status_code"] != 404 {
+		t.Errorf("Unmatched span was %q with status %v", unmatched.Name, unmatched.Attributes["http.response.status_code"])
+	}
+	if !unmatched.Parent.IsZero() || unmatched.Context.TraceID == server.Context.TraceID {
+		t.Error("Unmatched span should start a new trace")
+	}
+}
+
+func TestNotSampled(t *testing.T) {
+	exporter := &MemoryExporter{}
+	mux := yam.New()
+	mux.Use(New(exporter).Middleware)
+	mux.Route("/").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(exporter.Spans()) != 0 {
+		t.Error("Spans of unsampled traces should not be exported")
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := New(NewStdoutExporter(&buf))
+	_, span := tracer.Start(httptest.NewRequest("GET", "/", nil).Context(), "work")
+	span.SetAttribute("answer", 42)
+	span.Finish()
+	span.Finish()
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("Output %q should be a single JSON object: %v", buf.String(), err)
+	}
+	if m["name"] != "work" || m["trace_id"] != span.Context.TraceID.String() {
+		t.Errorf("Output was %s", buf.String())
+	}
+	if attrs, _ := m["attributes"].(map[string]interface{}); attrs["answer"] != float64(42) {
+		t.Errorf("Attributes were %v", m["attributes"])
+	}
+}
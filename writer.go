@@ -0,0 +1,302 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A http.ResponseWriter that records the response written through it,
+// returned by WrapWriter
+type ResponseWriter interface {
+	http.ResponseWriter
+
+	// Returns the status code of the response, 200 if the header has not
+	// been written as that is what net/http will send
+	Status() int
+
+	// Returns the number of body bytes written
+	BytesWritten() int64
+
+	// Returns the time the header was written, the zero time if it has not
+	// been written yet
+	HeaderWrittenAt() time.Time
+
+	// Returns the wrapped writer, this allows http.ResponseController to
+	// reach the underlying connection
+	Unwrap() http.ResponseWriter
+}
+
+// Wraps a http.ResponseWriter to record the status, number of bytes written
+// and the time the header was written. The returned writer implements exactly
+// the optional interfaces of the wrapped writer out of http.Flusher,
+// http.Hijacker, io.ReaderFrom and http.Pusher, so streaming and websockets
+// keep working through middleware.
+func WrapWriter(w http.ResponseWriter) ResponseWriter {
+	if rw, ok := w.(ResponseWriter); ok {
+		return rw
+	}
+
+	return wrap(&writer{ResponseWriter: w})
+}
+
+// Base writer recording the response
+type writer struct {
+	http.ResponseWriter
+	status  int
+	bytes   int64
+	wroteAt time.Time
+
+	head       bool // Discard the body, the header is sent when finished
+	headerSent bool
+}
+
+func (w *writer) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *writer) BytesWritten() int64 {
+	return w.bytes
+}
+
+func (w *writer) HeaderWrittenAt() time.Time {
+	return w.wroteAt
+}
+
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *writer) WriteHeader(code int) {
+	// Informational responses can be sent any number of times before the
+	// final header
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status != 0 {
+		if !w.head {
+			// Let net/http warn about the superfluous call
+			w.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+
+	w.status = code
+	w.wroteAt = time.Now()
+	if !w.head {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.head {
+		w.bytes += int64(len(b))
+		return len(b), nil
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Sends the header held back for a HEAD request
+func (w *writer) sendHeader() {
+	if !w.head || w.headerSent {
+		return
+	}
+	w.headerSent = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+		w.wroteAt = time.Now()
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// Finishes a HEAD response, setting the Content-Length to the size of the
+// discarded body if the handler did not set it
+func (w *writer) finish() {
+	if !w.head || w.headerSent {
+		return
+	}
+	h := w.Header()
+	if h.Get("Content-Length") == "" && h.Get("Transfer-Encoding") == "" && w.bytes > 0 &&
+		w.Status() != http.StatusNoContent && w.Status() != http.StatusNotModified {
+		h.Set("Content-Length", strconv.FormatInt(w.bytes, 10))
+	}
+	w.sendHeader()
+}
+
+type flusher struct{ w *writer }
+
+func (f flusher) Flush() {
+	if f.w.status == 0 {
+		f.w.WriteHeader(http.StatusOK)
+	}
+	f.w.sendHeader()
+	f.w.ResponseWriter.(http.Flusher).Flush()
+}
+
+type hijacker struct{ w *writer }
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+type readerFrom struct{ w *writer }
+
+func (rf readerFrom) ReadFrom(src io.Reader) (int64, error) {
+	if rf.w.status == 0 {
+		rf.w.WriteHeader(http.StatusOK)
+	}
+	if rf.w.head {
+		n, err := io.Copy(io.Discard, src)
+		rf.w.bytes += n
+		return n, err
+	}
+
+	n, err := rf.w.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	rf.w.bytes += n
+	return n, err
+}
+
+type pusher struct{ w *writer }
+
+func (p pusher) Push(target string, opts *http.PushOptions) error {
+	return p.w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+const (
+	flusherBit = 1 << iota
+	hijackerBit
+	readerFromBit
+	pusherBit
+)
+
+// Returns the writer as a type implementing the same optional interfaces as
+// the writer it wraps
+func wrap(w *writer) ResponseWriter {
+	mask := 0
+	if _, ok := w.ResponseWriter.(http.Flusher); ok {
+		mask |= flusherBit
+	}
+	if _, ok := w.ResponseWriter.(http.Hijacker); ok {
+		mask |= hijackerBit
+	}
+	if _, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		mask |= readerFromBit
+	}
+	if _, ok := w.ResponseWriter.(http.Pusher); ok {
+		mask |= pusherBit
+	}
+
+	switch mask {
+	case flusherBit:
+		return struct {
+			*writer
+			http.Flusher
+		}{w, flusher{w}}
+	case hijackerBit:
+		return struct {
+			*writer
+			http.Hijacker
+		}{w, hijacker{w}}
+	case flusherBit | hijackerBit:
+		return struct {
+			*writer
+			http.Flusher
+			http.Hijacker
+		}{w, flusher{w}, hijacker{w}}
+	case readerFromBit:
+		return struct {
+			*writer
+			io.ReaderFrom
+		}{w, readerFrom{w}}
+	case flusherBit | readerFromBit:
+		return struct {
+			*writer
+			http.Flusher
+			io.ReaderFrom
+		}{w, flusher{w}, readerFrom{w}}
+	case hijackerBit | readerFromBit:
+		return struct {
+			*writer
+			http.Hijacker
+			io.ReaderFrom
+		}{w, hijacker{w}, readerFrom{w}}
+	case flusherBit | hijackerBit | readerFromBit:
+		return struct {
+			*writer
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, flusher{w}, hijacker{w}, readerFrom{w}}
+	case pusherBit:
+		return struct {
+			*writer
+			http.Pusher
+		}{w, pusher{w}}
+	case flusherBit | pusherBit:
+		return struct {
+			*writer
+			http.Flusher
+			http.Pusher
+		}{w, flusher{w}, pusher{w}}
+	case hijackerBit | pusherBit:
+		return struct {
+			*writer
+			http.Hijacker
+			http.Pusher
+		}{w, hijacker{w}, pusher{w}}
+	case flusherBit | hijackerBit | pusherBit:
+		return struct {
+			*writer
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, flusher{w}, hijacker{w}, pusher{w}}
+	case readerFromBit | pusherBit:
+		return struct {
+			*writer
+			io.ReaderFrom
+			http.Pusher
+		}{w, readerFrom{w}, pusher{w}}
+	case flusherBit | readerFromBit | pusherBit:
+		return struct {
+			*writer
+			http.Flusher
+			io.ReaderFrom
+			http.Pusher
+		}{w, flusher{w}, readerFrom{w}, pusher{w}}
+	case hijackerBit | readerFromBit | pusherBit:
+		return struct {
+			*writer
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, hijacker{w}, readerFrom{w}, pusher{w}}
+	case flusherBit | hijackerBit | readerFromBit | pusherBit:
+		return struct {
+			*writer
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, flusher{w}, hijacker{w}, readerFrom{w}, pusher{w}}
+	}
+
+	return w
+}
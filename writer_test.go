@@ -0,0 +1,125 @@
+// Copyright 2015 SOON_ London Limited. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT).
+// This can be found in the LICENSE file at the repository root.
+
+package yam
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type baseWriter struct{ http.ResponseWriter }
+type flushWriter struct{ baseWriter }
+type hijackWriter struct{ baseWriter }
+type readerFromWriter struct{ baseWriter }
+type allWriter struct{ baseWriter }
+
+func (flushWriter) Flush() {}
+
+func (hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, nil }
+
+func (readerFromWriter) ReadFrom(io.Reader) (int64, error) { return 0, nil }
+
+func (allWriter) Flush()                                       {}
+func (allWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, nil }
+func (allWriter) ReadFrom(io.Reader) (int64, error)            { return 0, nil }
+func (allWriter) Push(string, *http.PushOptions) error         { return nil }
+
+func TestWrapWriterInterfaces(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var tests = []struct {
+		writer                                http.ResponseWriter
+		flusher, hijacker, readerFrom, pusher bool
+	}{
+		{baseWriter{rec}, false, false, false, false},
+		{flushWriter{baseWriter{rec}}, true, false, false, false},
+		{hijackWriter{baseWriter{rec}}, false, true, false, false},
+		{readerFromWriter{baseWriter{rec}}, false, false, true, false},
+		{allWriter{baseWriter{rec}}, true, true, true, true},
+	}
+
+	for i, test := range tests {
+		w := WrapWriter(test.writer)
+		_, flusher := w.(http.Flusher)
+		_, hijacker := w.(http.Hijacker)
+		_, readerFrom := w.(io.ReaderFrom)
+		_, pusher := w.(http.Pusher)
+
+		if flusher != test.flusher || hijacker != test.hijacker || readerFrom != test.readerFrom || pusher != test.pusher {
+			t.Errorf("%d: Flusher %v, Hijacker %v, ReaderFrom %v, Pusher %v, should be %v, %v, %v, %v", i,
+				flusher, hijacker, readerFrom, pusher,
+				test.flusher, test.hijacker, test.readerFrom, test.pusher)
+		}
+		if w.Unwrap() != test.writer {
+			t.Errorf("%d: Unwrap should return the wrapped writer", i)
+		}
+	}
+}
+
+func TestWrapWriterRecords(t *testing.T) {
+	w := WrapWriter(httptest.NewRecorder())
+
+	if w.Status() != http.StatusOK || !w.HeaderWrittenAt().IsZero() {
+		t.Error("Unwritten response should report 200 and a zero header time")
+	}
+
+	w.WriteHeader(http.StatusTeapot)
+	w.Write([]byte("short"))
+	io.Copy(w, strings.NewReader(" and stout"))
+
+	if w.Status() != http.StatusTeapot {
+		t.Errorf("Status was %v, should be %v", w.Status(), http.StatusTeapot)
+	}
+	if w.BytesWritten() != 15 {
+		t.Errorf("BytesWritten was %v, should be %v", w.BytesWritten(), 15)
+	}
+	if w.HeaderWrittenAt().IsZero() {
+		t.Error("HeaderWrittenAt should be set")
+	}
+	if WrapWriter(w) != w {
+		t.Error("Wrapping a wrapped writer should return it")
+	}
+}
+
+func TestHeadContentLength(t *testing.T) {
+	mux := New()
+	mux.Route("/").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("Writer should implement http.Flusher")
+		}
+		w.Write([]byte("Hello World"))
+	}))
+
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	res, err := http.Head(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Status was %v, should be %v", res.StatusCode, http.StatusOK)
+	}
+	if res.ContentLength != 11 {
+		t.Errorf("Content-Length was %v, should be %v", res.ContentLength, 11)
+	}
+	if len(body) != 0 {
+		t.Errorf("Body was %q, should be empty", body)
+	}
+
+	// The body is discarded by YAM, not only by net/http
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("HEAD", "/", nil))
+	if rec.Body.Len() != 0 {
+		t.Errorf("Body was %q, should be empty", rec.Body.String())
+	}
+}
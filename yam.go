@@ -7,8 +7,12 @@ package yam
 import (
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Configuration type that allows configuration of YAM
@@ -18,6 +22,26 @@ type Config struct {
 	Trace          bool
 	TraceHandler   func(*Route) http.Handler
 	AddHeadOnGet   bool
+	ErrorHandler   func(http.ResponseWriter, *http.Request, error)
+	Recover        bool
+	PanicHandler   func(*http.Request, *Panic)
+
+	RequestID          bool
+	RequestIDHeader    string
+	RequestIDGenerator func() string
+
+	Timeout      time.Duration
+	MaxBodyBytes int64
+	Compression  *Compressor
+	ETag         ETagMode
+	Cache        *ResponseCache
+	Authorizer   Authorizer
+
+	SecurityHeaders *SecurityHeaders
+	TrustedProxies  []netip.Prefix // Proxies forwarding headers are trusted from
+
+	RedirectHTTPS bool   // Plain HTTP requests are redirected to HTTPS
+	CanonicalHost string // Requests for other hosts are redirected to this host
 }
 
 // Constructs a new Config instance with default values
@@ -28,6 +52,26 @@ func NewConfig() *Config {
 		Trace:          false,
 		TraceHandler:   DefaultTraceHandler,
 		AddHeadOnGet:   true,
+		ErrorHandler:   DefaultErrorHandler,
+		Recover:        false,
+		PanicHandler:   DefaultPanicHandler,
+
+		RequestID:          false,
+		RequestIDHeader:    "X-Request-ID",
+		RequestIDGenerator: DefaultRequestIDGenerator,
+
+		Timeout:      0,
+		MaxBodyBytes: 0,
+		Compression:  nil,
+		ETag:         NoETag,
+		Cache:        NewResponseCache(1000),
+		Authorizer:   nil,
+
+		SecurityHeaders: nil,
+		TrustedProxies:  nil,
+
+		RedirectHTTPS: false,
+		CanonicalHost: "",
 	}
 }
 
@@ -35,6 +79,8 @@ func NewConfig() *Config {
 type Yam struct {
 	Root   *Route
 	Config *Config
+
+	middleware []func(http.Handler) http.Handler
 }
 
 // Constructs a new YAM instance with default configuration
@@ -53,6 +99,22 @@ func (y *Yam) Route(path string) *Route {
 	return route
 }
 
+// Adds middleware to the mux. Unlike middleware wrapping the mux, it runs after
+// the request has been matched to a route so CurrentRoute is available, and it
+// also wraps YAM's own responses such as 404 and 405. Middleware is applied in
+// the order it is added, the first added being the outermost.
+func (y *Yam) Use(middleware ...func(http.Handler) http.Handler) *Yam {
+	y.middleware = append(y.middleware, middleware...)
+
+	return y
+}
+
+// Calls fn for every route in the tree, parents before their children, stopping
+// at the first error
+func (y *Yam) Walk(fn func(*Route) error) error {
+	return y.Root.Walk(fn)
+}
+
 // Gets or Creates the route for the path. As it traverses the tree routes
 // are either created if they do not exist. At the end the function returns
 // the last leaf of the tree
@@ -79,7 +141,7 @@ func route(path string, base *Route, y *Yam) *Route {
 		}
 		if !found {
 			// The part of the path does not exist in the routes, create it
-			r := &Route{leaf: part, yam: y}
+			r := &Route{leaf: part, path: route.path + "/" + part, parent: route, yam: y}
 			// Add the route to the list of routes
 			route.Routes = append(route.Routes, r)
 			// Set the next route to be the one we just created
@@ -93,47 +155,161 @@ func route(path string, base *Route, y *Yam) *Route {
 // Implements the http.Handler Interface.  Finds the correct handler for
 // a path based on the path and http verb of the request.
 func (y *Yam) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = withValue(r, yamKey, y)
+	if y.Config.RequestID {
+		r = y.requestID(w, r)
+	}
+	// Matching adds the route values to the query, redirects keep the query
+	// as sent
+	query := r.URL.RawQuery
+	route, params := y.match(r)
+
+	// Reuse a RouteInfo placed on the request by outer middleware so it can
+	// see the matched route once we return
+	info, ok := r.Context().Value(routeKey).(*RouteInfo)
+	if ok {
+		*info = RouteInfo{}
+	} else {
+		info = &RouteInfo{}
+		r = withValue(r, routeKey, info)
+	}
+	ip := y.clientIP(r)
+	r = withValue(r, clientIPKey, ip)
+
+	var handler http.Handler
+	var target string
+	if y.Config.RedirectHTTPS || y.Config.CanonicalHost != "" {
+		if route == nil || route.effectiveRedirects() {
+			target = y.redirect(r, query)
+		}
+	}
+
+	switch {
+	// Redirect to HTTPS or the canonical host before anything else
+	case target != "":
+		handler = redirectHandler(target)
+	// If we have not found a route serve a 404 Not Found
+	case route == nil:
+		handler = notFoundHandler
+	// Do we have a handler for this Verb, if not serve a 405 Method Not Allowed
+	case route.handlers[r.Method] == nil:
+		handler = methodNotAllowedHandler
+	default:
+		handler = route.handler(r.Method)
+		*info = RouteInfo{
+			Route:   route,
+			Pattern: route.Pattern(),
+			Params:  params,
+			Meta:    route.Metadata(),
+		}
+	}
+	info.clientIP = ip
+	info.requestID = RequestID(r)
+
+	// Security headers are set here rather than in the route chain so YAM's
+	// own responses get them too
+	security := y.Config.SecurityHeaders
+	if route != nil {
+		security = route.effectiveSecurityHeaders()
+	}
+	if security != nil {
+		r = security.apply(w, r)
+	}
+
+	if r.Method == "HEAD" {
+		handler = headHandler(handler)
+	}
+
+	if y.Config.Recover {
+		handler = y.recoverer(route, handler)
+	}
+
+	// Apply the mux middleware, the first added is the outermost
+	for i := len(y.middleware) - 1; i >= 0; i-- {
+		handler = y.middleware[i](handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// Wraps a handler serving a HEAD request so the body it writes is discarded,
+// allowing GET handlers to serve HEAD requests
+func headHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &writer{ResponseWriter: w, head: true}
+		next.ServeHTTP(wrap(hw), r)
+		hw.finish()
+	})
+}
+
+// Default handlers for requests that do not match a route handler
+var (
+	notFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Error(w, r, NewProblem(http.StatusNotFound, ""))
+	})
+	methodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Error(w, r, NewProblem(http.StatusMethodNotAllowed, ""))
+	})
+)
+
+// Walks the route tree to find the route for the request path, returns nil
+// when no route with handlers matches. Pattern values along the path are
+// returned and added to the request URL query. A "*name" catch-all segment
+// matches the rest of the path when no other route at its depth matches.
+func (y *Yam) match(r *http.Request) (*Route, url.Values) {
+	var route *Route
 	parts := strings.Split(r.URL.Path, "/")[1:]
 	routes := y.Root.Routes
+	values := url.Values{}
 
 	for i, part := range parts {
-		for _, route := range routes {
-			match := false
+		var next, catchAll *Route
+		for _, candidate := range routes {
+			// Catch-all match, only used when nothing else matches
+			if strings.HasPrefix(candidate.leaf, "*") {
+				catchAll = candidate
+				continue
+			}
 			// Pattern Match
-			if strings.HasPrefix(route.leaf, ":") {
-				match = true
-				values := url.Values{}
-				values.Add(route.leaf, part)
-				r.URL.RawQuery = url.Values(values).Encode() + "&" + r.URL.RawQuery
-			} else { // Exact match
-				if route.leaf == part {
-					match = true
-				}
+			if strings.HasPrefix(candidate.leaf, ":") {
+				values.Add(candidate.leaf, part)
+				next = candidate
+				break
 			}
-			// Did we get a match
-			if match {
-				// If we are not at the end of the path, then we go round again
-				if i < len(parts)-1 {
-					routes = route.Routes
-					break
-				} else { // We are at the end of the path - we have a route
-					handler := route.handlers[r.Method]
-					// Do we have a handler for this Verb
-					if handler != nil {
-						// Yes, serve and return
-						handler.ServeHTTP(w, r)
-						return
-					}
-					// We do not - Serve a 405 Method Not Allowed
-					w.WriteHeader(http.StatusMethodNotAllowed)
-					return
-				}
+			// Exact match
+			if candidate.leaf == part {
+				next = candidate
+				break
 			}
 		}
+		// The catch-all takes the rest of the path
+		if next == nil && catchAll != nil {
+			values.Add(catchAll.leaf, strings.Join(parts[i:], "/"))
+			route = catchAll
+			break
+		}
+		// Nothing at this depth matches the path
+		if next == nil {
+			return nil, nil
+		}
+		// Go round again with the children of the match
+		route = next
+		routes = route.Routes
+	}
+
+	if route == nil || len(route.handlers) == 0 {
+		return nil, nil
+	}
+
+	if len(values) > 0 {
+		query := values.Encode()
+		if r.URL.RawQuery != "" {
+			query += "&" + r.URL.RawQuery
+		}
+		r.URL.RawQuery = query
 	}
 
-	// If we get here then we have not found a route
-	w.WriteHeader(http.StatusNotFound)
+	return route, values
 }
 
 // This type contains all the handlers for each path, each Route can also hold
@@ -141,12 +317,182 @@ func (y *Yam) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 type Route struct {
 	leaf   string   // a part of a URL path, /foo/bar - a leaf would be foo and bar
 	path   string   // full url path
+	parent *Route   // Route this route lives under, nil for the root
+	name   string   // name of the route, cached responses can be purged by name
 	Routes []*Route // Routes that live under this route
 
 	yam *Yam // Reference to Yam and global configuration
 
+	// User defined metadata
+	meta map[string]interface{}
+
+	// Behaviour applied to the handlers of this route and the routes under it,
+	// nil when inherited
+	timeout     *time.Duration
+	maxBody     *int64
+	compress    *bool
+	decompress  *int64
+	etag        *ETagMode
+	cache       *cachePolicy
+	coalesce    *coalescer
+	idempotency *Idempotency
+	security    *SecurityHeaders
+	redirects   *bool
+
+	// IP address ranges allowed and denied access, checked against the
+	// ranges of the parent routes too
+	allowIPs []netip.Prefix
+	denyIPs  []netip.Prefix
+
+	// Requirements checked by Config.Authorizer
+	require       []string
+	requireMethod map[string][]string
+
+	// Middleware applied to the handlers of this route and the routes under it
+	middleware []func(http.Handler) http.Handler
+
+	// Limiters attached to the route, kept for introspection
+	concurrency *ConcurrencyLimiter
+	shedder     *AdaptiveLimiter
+
 	// Verb handlers
-	handlers map[string]http.Handler
+	handlers    map[string]http.Handler
+	headFromGet bool // The HEAD handler is the GET handler
+
+	// Verb handlers wrapped by chain, built the first time a method is served
+	chains  sync.Map
+	chainMu sync.Mutex
+}
+
+// Returns the full path pattern of the route, for example "/users/:id"
+func (r *Route) Pattern() string {
+	return r.path
+}
+
+// Returns the sorted HTTP methods the route has handlers for
+func (r *Route) Methods() []string {
+	methods := make([]string, 0, len(r.handlers))
+	for method := range r.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	return methods
+}
+
+// Calls fn for this route and every route under it, parents before their
+// children, stopping at the first error
+func (r *Route) Walk(fn func(*Route) error) error {
+	if err := fn(r); err != nil {
+		return err
+	}
+	for _, route := range r.Routes {
+		if err := route.Walk(fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sets a user defined metadata value on the route, metadata is inherited by
+// the routes under this route and is available to handlers and middleware
+// through CurrentRoute
+func (r *Route) Meta(key string, value interface{}) *Route {
+	if r.meta == nil {
+		r.meta = make(map[string]interface{})
+	}
+	r.meta[key] = value
+
+	return r
+}
+
+// Returns the metadata of the route merged with the metadata of the routes it
+// lives under, values set closer to the route take precedence
+func (r *Route) Metadata() map[string]interface{} {
+	meta := make(map[string]interface{})
+	var merge func(*Route)
+	merge = func(route *Route) {
+		if route == nil {
+			return
+		}
+		merge(route.parent)
+		for k, v := range route.meta {
+			meta[k] = v
+		}
+	}
+	merge(r)
+
+	return meta
+}
+
+// Returns the handler for a method wrapped by chain. The chain is built once,
+// the first time the method is served, so middleware only wraps it once and
+// the route should be configured before it serves requests.
+func (r *Route) handler(method string) http.Handler {
+	if h, ok := r.chains.Load(method); ok {
+		return h.(http.Handler)
+	}
+
+	r.chainMu.Lock()
+	defer r.chainMu.Unlock()
+	if h, ok := r.chains.Load(method); ok {
+		return h.(http.Handler)
+	}
+	h := r.chain(r.handlers[method])
+	r.chains.Store(method, h)
+
+	return h
+}
+
+// Wraps a handler of the route with the behaviour configured for the route
+// and the routes it lives under
+func (r *Route) chain(h http.Handler) http.Handler {
+	if d := r.effectiveTimeout(); d > 0 {
+		h = timeoutHandler(h, d)
+	}
+	if i := r.effectiveIdempotency(); i != nil {
+		h = i.handler(h, r.Pattern())
+	}
+	if n := r.effectiveDecompress(); n > 0 {
+		h = decompressHandler(h, n)
+	}
+	if n := r.effectiveMaxBody(); n > 0 {
+		h = maxBodyHandler(h, n)
+	}
+	if g := r.effectiveCoalescer(); g != nil {
+		h = g.handler(h)
+	}
+	if p := r.effectiveCache(); p != nil {
+		h = cacheHandler(h, r, p)
+	}
+	if mode := r.effectiveETag(); mode != NoETag {
+		h = etagHandler(h, r, mode)
+	}
+	if c := r.effectiveCompressor(); c != nil {
+		h = c.Middleware(h)
+	}
+
+	h = r.authorizeHandler(h)
+
+	// Route middleware, middleware of parent routes is the outermost
+	for route := r; route != nil; route = route.parent {
+		for i := len(route.middleware) - 1; i >= 0; i-- {
+			h = route.middleware[i](h)
+		}
+	}
+
+	return r.ipFilter(h)
+}
+
+// Adds middleware to the route, it wraps the handlers of this route and of
+// the routes under it. Middleware is applied in the order it is added, the
+// first added being the outermost, and middleware of parent routes wraps
+// middleware of their children.
+func (r *Route) Use(middleware ...func(http.Handler) http.Handler) *Route {
+	r.middleware = append(r.middleware, middleware...)
+
+	return r
 }
 
 // Adds a new route to the tree, and depending on configuration implements
@@ -176,6 +522,10 @@ func (r *Route) Route(path string) *Route {
 // Adds a new handler to the route based on http Verb
 func (r *Route) Add(method string, h http.Handler) *Route {
 	r.handlers[method] = h
+	r.chains.Delete(method)
+	if method == "HEAD" {
+		r.headFromGet = false
+	}
 
 	return r
 }
@@ -210,6 +560,7 @@ func (r *Route) Get(h http.Handler) *Route {
 	if r.yam.Config.AddHeadOnGet {
 		// Apply the head middleware to the head handler
 		r.Add("HEAD", h)
+		r.headFromGet = true
 	}
 
 	return r
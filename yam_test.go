@@ -67,7 +67,7 @@ var tests = []struct {
 			w.Write([]byte(r.URL.Path))
 		})},
 		TestRequest{"/bar", "GET"},
-		TestResponse{http.StatusNotFound, nil},
+		TestResponse{http.StatusNotFound, []byte("404 Not Found\n")},
 	},
 	// 405 Handling
 	{
@@ -77,7 +77,7 @@ var tests = []struct {
 			w.Write([]byte(r.URL.Path))
 		})},
 		TestRequest{"/foo", "POST"},
-		TestResponse{http.StatusMethodNotAllowed, nil},
+		TestResponse{http.StatusMethodNotAllowed, []byte("405 Method Not Allowed\n")},
 	},
 	// Pattern Matching & Added to Query
 	{
@@ -101,6 +101,16 @@ var tests = []struct {
 		TestRequest{"/a/b/c/f/e/f/g/o/i/j/o", "GET"},
 		TestResponse{http.StatusOK, []byte("foo")},
 	},
+	// Catch-all Matching
+	{
+		NewConfig(),
+		TestRoute{"/files/*path", []string{"GET"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(r.URL.Query().Get("*path")))
+		})},
+		TestRequest{"/files/a/b/c.txt", "GET"},
+		TestResponse{http.StatusOK, []byte("a/b/c.txt")},
+	},
 }
 
 func TestTables(t *testing.T) {
@@ -468,6 +478,7 @@ func TestSubRoutes(t *testing.T) {
 	foo := mux.Route("/foo").Get(fn("GET /foo"))
 	foo.Route("/:bar/baz").Get(fn("GET /foo/:bar/baz"))
 	mux.Route("/foo").Put(fn("PUT /foo"))
+	mux.Route("/*path").Get(fn("GET /*path"))
 
 	s := httptest.NewServer(mux)
 	defer s.Close()
@@ -501,6 +512,14 @@ func TestSubRoutes(t *testing.T) {
 			TestRequest{"/foo/antyhing/baz", "GET"},
 			TestResponse{http.StatusOK, []byte("GET /foo/:bar/baz")},
 		},
+		{
+			TestRequest{"/baz/qux", "GET"},
+			TestResponse{http.StatusOK, []byte("GET /*path")},
+		},
+		{
+			TestRequest{"/bar/baz", "GET"},
+			TestResponse{http.StatusNotFound, []byte("404 Not Found\n")},
+		},
 	}
 
 	for _, test := range tests {
@@ -514,3 +533,94 @@ func TestSubRoutes(t *testing.T) {
 	}
 
 }
+
+func TestUse(t *testing.T) {
+	middleware := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				pattern := "-"
+				if info := CurrentRoute(r); info != nil {
+					pattern = info.Pattern
+				}
+				w.Header().Add("Middleware", name+" "+pattern)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	mux := New()
+	mux.Use(middleware("first"), middleware("second"))
+	mux.Route("/foo/:bar").Get(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var tests = []struct {
+		path     string
+		status   int
+		expected []string
+	}{
+		{"/foo/bar", http.StatusOK, []string{"first /foo/:bar", "second /foo/:bar"}},
+		{"/bar", http.StatusNotFound, []string{"first -", "second -"}},
+	}
+
+	for _, test := range tests {
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest("GET", test.path, nil))
+
+		if res.Code != test.status {
+			t.Errorf("Status was %v, should be %v", res.Code, test.status)
+		}
+		if strings.Join(res.Header()["Middleware"], ", ") != strings.Join(test.expected, ", ") {
+			t.Errorf("Middleware was %v, should be %v", res.Header()["Middleware"], test.expected)
+		}
+	}
+}
+
+func TestRouteChainBuiltOnce(t *testing.T) {
+	wrapped := 0
+	fn := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		})
+	}
+
+	mux := New()
+	route := mux.Route("/foo").Use(func(next http.Handler) http.Handler {
+		wrapped++
+		return next
+	}).Get(fn("first"))
+
+	for i := 0; i < 3; i++ {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/foo", nil))
+	}
+	if wrapped != 1 {
+		t.Errorf("Middleware wrapped %d times, should be %d", wrapped, 1)
+	}
+
+	// Replacing a handler rebuilds its chain
+	route.Get(fn("second"))
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, httptest.NewRequest("GET", "/foo", nil))
+	if res.Body.String() != "second" || wrapped != 2 {
+		t.Errorf("Body was %q after %d wraps, should be %q after %d", res.Body.String(), wrapped, "second", 2)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mux := New()
+	mux.Route("/users/:id").Get(fn).Delete(fn)
+	mux.Route("/about").Get(fn)
+
+	var patterns []string
+	mux.Walk(func(r *Route) error {
+		if len(r.Methods()) > 0 {
+			patterns = append(patterns, r.Pattern()+" "+strings.Join(r.Methods(), ","))
+		}
+		return nil
+	})
+
+	expected := "/users/:id DELETE,GET,HEAD,OPTIONS; /about GET,HEAD,OPTIONS"
+	if strings.Join(patterns, "; ") != expected {
+		t.Errorf("Walked %v, should be %v", strings.Join(patterns, "; "), expected)
+	}
+}